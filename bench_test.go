@@ -0,0 +1,350 @@
+package mptrie
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+
+	ethtrie "github.com/ethereum/go-ethereum/trie"
+)
+
+// the benchmarks run every workload on both tries, over each size and key
+// pattern, named as Benchmark<Workload>/<size>/<pattern>/<trie>, so one trie
+// can be compared against the other with -bench 'Get/100k/random'. Sizes
+// above 100k are skipped with -short.
+var benchSizes = []struct {
+	name string
+	keys int
+}{
+	{"1k", 1_000},
+	{"100k", 100_000},
+	{"1M", 1_000_000},
+}
+
+const (
+	patternSequential = "sequential"
+	patternRandom     = "random"
+
+	// benchProofs is how many proofs are verified round robin, they are
+	// built once per fixture
+	benchProofs = 16
+)
+
+// benchKeys returns n keys from position start on, sequential keys are 8 byte
+// big endian counters so neighbours share long prefixes, random ones are 32
+// bytes like hashed keys
+func benchKeys(pattern string, start, n int) [][]byte {
+	keys := make([][]byte, n)
+
+	if pattern == patternSequential {
+		for i := range keys {
+			keys[i] = make([]byte, 8)
+			binary.BigEndian.PutUint64(keys[i], uint64(start+i))
+		}
+
+		return keys
+	}
+
+	r := rand.New(rand.NewSource(int64(start)))
+	for i := range keys {
+		keys[i] = make([]byte, 32)
+		r.Read(keys[i])
+	}
+
+	return keys
+}
+
+func benchValue(i int) []byte {
+	value := make([]byte, 32)
+	binary.BigEndian.PutUint64(value[24:], uint64(i))
+	return value
+}
+
+// benchFixture is a trie of each kind holding the same keys, both are hashed
+// so the benchmarks start from the same state. Only the last fixture is kept,
+// the largest ones take hundreds of megabytes.
+type benchFixture struct {
+	size    int
+	pattern string
+	keys    [][]byte
+	trie    *Trie
+	eth     *ethtrie.Trie
+
+	// committed and ethCommitted are the same tries opened from the
+	// in-memory key/value store they were committed to, so both load and
+	// decode the nodes they walk. Hash and proofs are benchmarked on them,
+	// this trie does not keep node hashes and hashes every node otherwise.
+	committed    *Trie
+	ethCommitted *ethtrie.Trie
+
+	// proofs of the first keys, made by each trie
+	proofs, ethProofs []*memorydb.Database
+}
+
+var lastFixture *benchFixture
+
+func loadFixture(b *testing.B, size int, pattern string) *benchFixture {
+	b.Helper()
+
+	if lastFixture != nil && lastFixture.size == size && lastFixture.pattern == pattern {
+		return lastFixture
+	}
+
+	lastFixture = nil
+
+	f := &benchFixture{size: size, pattern: pattern, keys: benchKeys(pattern, 0, size)}
+	f.trie = buildBenchTrie(b, f.keys)
+	ethdb := ethtrie.NewDatabase(memorydb.New())
+	f.eth = buildBenchEth(b, ethdb, f.keys)
+
+	db := NewInMemoryStorage()
+	root, err := f.trie.Commit(db)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	f.committed = OpenTrie(root, db)
+
+	// geth commits copies of the nodes, the fixture stays in memory
+	eth := *f.eth
+	ethRoot, err := eth.Commit(nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	if err := ethdb.Commit(ethRoot, false, nil); err != nil {
+		b.Fatal(err)
+	}
+
+	if f.ethCommitted, err = ethtrie.New(ethRoot, ethdb); err != nil {
+		b.Fatal(err)
+	}
+
+	lastFixture = f
+	return f
+}
+
+func buildBenchTrie(b *testing.B, keys [][]byte) *Trie {
+	trie := NewTrie()
+	for i, key := range keys {
+		if err := trie.Put(key, benchValue(i)); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	trie.Hash()
+	return trie
+}
+
+func buildBenchEth(b *testing.B, db *ethtrie.Database, keys [][]byte) *ethtrie.Trie {
+	eth, err := ethtrie.New(common.Hash{}, db)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for i, key := range keys {
+		eth.Update(key, benchValue(i))
+	}
+
+	eth.Hash()
+	return eth
+}
+
+// benchWorkload runs run for every size, pattern and trie, with the fixture
+// already built out of the timer
+func benchWorkload(b *testing.B, run func(b *testing.B, f *benchFixture, geth bool)) {
+	for _, size := range benchSizes {
+		for _, pattern := range []string{patternSequential, patternRandom} {
+			for _, impl := range []string{"mptrie", "geth"} {
+				name := fmt.Sprintf("%s/%s/%s", size.name, pattern, impl)
+				keys := size.keys
+				pattern, geth := pattern, impl == "geth"
+
+				b.Run(name, func(b *testing.B) {
+					if testing.Short() && keys > 100_000 {
+						b.Skip("skipping large trie in short mode")
+					}
+
+					f := loadFixture(b, keys, pattern)
+
+					b.ReportAllocs()
+					b.ResetTimer()
+					run(b, f, geth)
+				})
+			}
+		}
+	}
+}
+
+// BenchmarkPut inserts new keys into a copy of the fixture, both tries copy
+// the nodes they change so the fixture is left as it was
+func BenchmarkPut(b *testing.B) {
+	benchWorkload(b, func(b *testing.B, f *benchFixture, geth bool) {
+		b.StopTimer()
+		keys := benchKeys(f.pattern, f.size, b.N)
+		trie, eth := *f.trie, *f.eth
+		b.StartTimer()
+
+		for i := 0; i < b.N; i++ {
+			if geth {
+				eth.Update(keys[i], benchValue(i))
+				continue
+			}
+
+			if err := trie.Put(keys[i], benchValue(i)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkGet(b *testing.B) {
+	benchWorkload(b, func(b *testing.B, f *benchFixture, geth bool) {
+		for i := 0; i < b.N; i++ {
+			key := f.keys[i%len(f.keys)]
+
+			if geth {
+				if f.eth.Get(key) == nil {
+					b.Fatalf("missing key %x", key)
+				}
+
+				continue
+			}
+
+			if _, ok := f.trie.Get(key); !ok {
+				b.Fatalf("missing key %x", key)
+			}
+		}
+	})
+}
+
+// BenchmarkHash updates a key of the committed tries and hashes them again,
+// both only load and hash the nodes along the path of the key. Each round
+// starts again from the committed trie, this one does not keep the hashes
+// of the nodes it loaded.
+func BenchmarkHash(b *testing.B) {
+	benchWorkload(b, func(b *testing.B, f *benchFixture, geth bool) {
+		for i := 0; i < b.N; i++ {
+			key := f.keys[i%len(f.keys)]
+
+			if geth {
+				eth := *f.ethCommitted
+				eth.Update(key, benchValue(-i))
+				eth.Hash()
+				continue
+			}
+
+			trie := *f.committed
+			if err := trie.Put(key, benchValue(-i)); err != nil {
+				b.Fatal(err)
+			}
+
+			trie.Hash()
+		}
+	})
+}
+
+// BenchmarkCreateProof proves keys of the committed tries
+func BenchmarkCreateProof(b *testing.B) {
+	benchWorkload(b, func(b *testing.B, f *benchFixture, geth bool) {
+		for i := 0; i < b.N; i++ {
+			key := f.keys[i%len(f.keys)]
+
+			if geth {
+				if err := f.ethCommitted.Prove(key, 0, memorydb.New()); err != nil {
+					b.Fatal(err)
+				}
+
+				continue
+			}
+
+			if err := CreateProof(key, f.committed, NewInMemoryStorage()); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// proofsOf returns the proofs of the first keys of the fixture made by one of
+// the tries, building them on the first call
+func (f *benchFixture) proofsOf(b *testing.B, geth bool) []*memorydb.Database {
+	b.Helper()
+
+	proofs := &f.proofs
+	if geth {
+		proofs = &f.ethProofs
+	}
+
+	if *proofs != nil {
+		return *proofs
+	}
+
+	n := benchProofs
+	if n > len(f.keys) {
+		n = len(f.keys)
+	}
+
+	for _, key := range f.keys[:n] {
+		proof := memorydb.New()
+
+		var err error
+		if geth {
+			err = f.ethCommitted.Prove(key, 0, proof)
+		} else {
+			err = CreateProof(key, f.committed, proof)
+		}
+
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		*proofs = append(*proofs, proof)
+	}
+
+	return *proofs
+}
+
+// BenchmarkVerifyProof checks the proofs of each trie with its own verifier
+func BenchmarkVerifyProof(b *testing.B) {
+	benchWorkload(b, func(b *testing.B, f *benchFixture, geth bool) {
+		b.StopTimer()
+		root := f.committed.Hash()
+		proofs := f.proofsOf(b, geth)
+		b.StartTimer()
+
+		for i := 0; i < b.N; i++ {
+			n := i % len(proofs)
+
+			var value []byte
+			var err error
+			if geth {
+				value, err = ethtrie.VerifyProof(common.BytesToHash(root), f.keys[n], proofs[n])
+			} else {
+				value, err = VerifyProof(root, f.keys[n], proofs[n])
+			}
+
+			if err != nil || value == nil {
+				b.Fatalf("proof of %x: %v", f.keys[n], err)
+			}
+		}
+	})
+}
+
+// BenchmarkBuild inserts every key of the fixture into an empty trie and
+// hashes it
+func BenchmarkBuild(b *testing.B) {
+	benchWorkload(b, func(b *testing.B, f *benchFixture, geth bool) {
+		for i := 0; i < b.N; i++ {
+			if geth {
+				buildBenchEth(b, ethtrie.NewDatabase(memorydb.New()), f.keys)
+				continue
+			}
+
+			buildBenchTrie(b, f.keys)
+		}
+	})
+}
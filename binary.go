@@ -0,0 +1,310 @@
+package mptrie
+
+import (
+	"bytes"
+	"errors"
+)
+
+// BinaryTrie is the radix-2 version of Trie: keys are followed bit by bit,
+// branches have 2 children and extensions skip the bits shared below them.
+type BinaryTrie struct {
+	root Node
+	e    binaryEncoder
+}
+
+// NewBinaryTrie builds an empty binary trie, WithHasher is the only option
+// that applies to it.
+func NewBinaryTrie(opts ...Option) *BinaryTrie {
+	return &BinaryTrie{e: binaryEncoder{hasher: NewTrie(opts...).encoder().hasher}}
+}
+
+func (t *BinaryTrie) Hash() []byte {
+	return t.e.hash(t.root)
+}
+
+func (t *BinaryTrie) Get(key []byte) ([]byte, bool) {
+	node := t.root
+	bits := BitsFromBytes(key)
+
+	for {
+		switch n := node.(type) {
+		case *BinaryLeafNode:
+			if PrefixMatchedBits(bits, n.Path) == len(bits) && len(bits) == len(n.Path) {
+				return n.Value, true
+			}
+
+			return nil, false
+		case *BinaryBranchNode:
+			if len(bits) == 0 {
+				return n.Value, n.Value != nil
+			}
+
+			node, bits = n.Children[bits[0]], bits[1:]
+		case *BinaryExtensionNode:
+			if PrefixMatchedBits(bits, n.Path) < len(n.Path) {
+				return nil, false
+			}
+
+			node, bits = n.Next, bits[len(n.Path):]
+		default:
+			return nil, false
+		}
+	}
+}
+
+func (t *BinaryTrie) Put(key, value []byte) error {
+	if len(key) == 0 {
+		return errors.New("cannot put an empty key")
+	}
+
+	t.root = t.put(t.root, BitsFromBytes(key), value)
+	return nil
+}
+
+// put returns n with value set at bits, every changed node is a copy so the
+// nodes can be shared
+func (t *BinaryTrie) put(n Node, bits []Bit, value []byte) Node {
+	switch node := n.(type) {
+	case nil:
+		return &BinaryLeafNode{Path: bits, Value: value}
+	case *BinaryLeafNode:
+		matched := PrefixMatchedBits(bits, node.Path)
+		if matched == len(bits) && matched == len(node.Path) {
+			return &BinaryLeafNode{Path: node.Path, Value: value}
+		}
+
+		branch := &BinaryBranchNode{}
+		t.attach(branch, node.Path[matched:], node.Value)
+		t.attach(branch, bits[matched:], value)
+		return t.extend(bits[:matched], branch)
+	case *BinaryBranchNode:
+		branch := node.clone()
+		if len(bits) == 0 {
+			branch.Value = value
+		} else {
+			branch.Children[bits[0]] = t.put(branch.Children[bits[0]], bits[1:], value)
+		}
+
+		return branch
+	case *BinaryExtensionNode:
+		matched := PrefixMatchedBits(bits, node.Path)
+		if matched == len(node.Path) {
+			return &BinaryExtensionNode{Path: node.Path, Next: t.put(node.Next, bits[matched:], value)}
+		}
+
+		branch := &BinaryBranchNode{}
+		branch.Children[node.Path[matched]] = t.extend(node.Path[matched+1:], node.Next)
+		t.attach(branch, bits[matched:], value)
+		return t.extend(bits[:matched], branch)
+	}
+
+	return n
+}
+
+// attach stores value in a new branch, at the branch itself when no bits are
+// left or in a leaf below it otherwise
+func (t *BinaryTrie) attach(branch *BinaryBranchNode, bits []Bit, value []byte) {
+	if len(bits) == 0 {
+		branch.Value = value
+		return
+	}
+
+	branch.Children[bits[0]] = &BinaryLeafNode{Path: bits[1:], Value: value}
+}
+
+// extend puts an extension with path in front of next, unless path is empty
+func (t *BinaryTrie) extend(path []Bit, next Node) Node {
+	if len(path) == 0 {
+		return next
+	}
+
+	return &BinaryExtensionNode{Path: path, Next: next}
+}
+
+// Delete removes key from the trie, like Trie.Delete deleting a key that does
+// not exist is not an error.
+func (t *BinaryTrie) Delete(key []byte) error {
+	if len(key) == 0 {
+		return errors.New("cannot delete an empty key")
+	}
+
+	t.root, _ = t.delete(t.root, BitsFromBytes(key))
+	return nil
+}
+
+func (t *BinaryTrie) delete(n Node, bits []Bit) (Node, bool) {
+	switch node := n.(type) {
+	case *BinaryLeafNode:
+		if PrefixMatchedBits(bits, node.Path) == len(bits) && len(bits) == len(node.Path) {
+			return nil, true
+		}
+	case *BinaryBranchNode:
+		branch := node.clone()
+
+		if len(bits) == 0 {
+			if branch.Value == nil {
+				return n, false
+			}
+
+			branch.Value = nil
+			return t.canonical(branch), true
+		}
+
+		child, removed := t.delete(branch.Children[bits[0]], bits[1:])
+		if !removed {
+			return n, false
+		}
+
+		branch.Children[bits[0]] = child
+		return t.canonical(branch), true
+	case *BinaryExtensionNode:
+		if PrefixMatchedBits(bits, node.Path) < len(node.Path) {
+			return n, false
+		}
+
+		next, removed := t.delete(node.Next, bits[len(node.Path):])
+		if !removed {
+			return n, false
+		}
+
+		return t.canonical(&BinaryExtensionNode{Path: node.Path, Next: next}), true
+	}
+
+	return n, false
+}
+
+// canonical returns the smallest form of a node left after a delete: a
+// branch needs 2 entries and an extension cannot be followed by a leaf or
+// another extension.
+func (t *BinaryTrie) canonical(n Node) Node {
+	switch node := n.(type) {
+	case *BinaryBranchNode:
+		children, last := 0, Bit(0)
+		for i, child := range node.Children {
+			if child != nil {
+				children, last = children+1, Bit(i)
+			}
+		}
+
+		switch {
+		case children == 0 && node.Value == nil:
+			return nil
+		case children == 0:
+			return &BinaryLeafNode{Path: []Bit{}, Value: node.Value}
+		case children == 1 && node.Value == nil:
+			return t.canonical(&BinaryExtensionNode{Path: []Bit{last}, Next: node.Children[last]})
+		}
+	case *BinaryExtensionNode:
+		switch next := node.Next.(type) {
+		case nil:
+			return nil
+		case *BinaryLeafNode:
+			return &BinaryLeafNode{Path: ConcatBits(node.Path, next.Path), Value: next.Value}
+		case *BinaryExtensionNode:
+			return &BinaryExtensionNode{Path: ConcatBits(node.Path, next.Path), Next: next.Next}
+		}
+	}
+
+	return n
+}
+
+// CreateProof writes into w every node on the path of key, keyed by its hash
+func (t *BinaryTrie) CreateProof(key []byte, w KVWriter) error {
+	node := t.root
+	bits := BitsFromBytes(key)
+
+	for {
+		if node == nil {
+			return KeyNotFound
+		}
+
+		if err := w.Put(t.e.hash(node), t.e.serialize(node)); err != nil {
+			return err
+		}
+
+		switch n := node.(type) {
+		case *BinaryLeafNode:
+			if PrefixMatchedBits(bits, n.Path) == len(bits) && len(bits) == len(n.Path) {
+				return nil
+			}
+
+			return KeyNotFound
+		case *BinaryBranchNode:
+			if len(bits) == 0 {
+				if n.Value == nil {
+					return KeyNotFound
+				}
+
+				return nil
+			}
+
+			node, bits = n.Children[bits[0]], bits[1:]
+		case *BinaryExtensionNode:
+			if PrefixMatchedBits(bits, n.Path) < len(n.Path) {
+				return KeyNotFound
+			}
+
+			node, bits = n.Next, bits[len(n.Path):]
+		}
+	}
+}
+
+// VerifyBinaryProof returns the value of key using only the nodes written by
+// CreateProof, checking each of them against root. A proof that ends before
+// reaching key proves it is not in the trie, nil is returned then like
+// VerifyProof does. The options must match the ones of the proved trie.
+func VerifyBinaryProof(root, key []byte, r NodeReader, opts ...Option) ([]byte, error) {
+	e := binaryEncoder{hasher: NewTrie(opts...).encoder().hasher}
+	if bytes.Equal(root, e.hash(nil)) {
+		return nil, nil
+	}
+
+	bits := BitsFromBytes(key)
+	want := root
+
+	for {
+		encoded, err := r.Get(want)
+		if err != nil {
+			return nil, err
+		}
+
+		if !bytes.Equal(e.hasher.Hash(encoded), want) {
+			return nil, ErrWhileProof
+		}
+
+		node, err := e.decode(encoded)
+		if err != nil {
+			return nil, err
+		}
+
+		var next Node
+		switch n := node.(type) {
+		case *BinaryLeafNode:
+			if PrefixMatchedBits(bits, n.Path) == len(bits) && len(bits) == len(n.Path) {
+				return n.Value, nil
+			}
+
+			return nil, nil
+		case *BinaryBranchNode:
+			if len(bits) == 0 {
+				return n.Value, nil
+			}
+
+			next, bits = n.Children[bits[0]], bits[1:]
+		case *BinaryExtensionNode:
+			if PrefixMatchedBits(bits, n.Path) < len(n.Path) {
+				return nil, nil
+			}
+
+			next, bits = n.Next, bits[len(n.Path):]
+		}
+
+		// children are always referenced by hash, an empty one ends the path
+		hash, ok := next.(HashNode)
+		if !ok {
+			return nil, nil
+		}
+
+		want = hash
+	}
+}
@@ -0,0 +1,169 @@
+package mptrie
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// BinaryLeafNode holds a value at the end of the remaining bits of its key
+type BinaryLeafNode struct {
+	Path  []Bit
+	Value []byte
+}
+
+// BinaryBranchNode splits the keys by their next bit, Value is set when a key
+// ends at the branch
+type BinaryBranchNode struct {
+	Children [2]Node
+	Value    []byte
+}
+
+// BinaryExtensionNode skips the bits shared by every key below it
+type BinaryExtensionNode struct {
+	Path []Bit
+	Next Node
+}
+
+func (l BinaryLeafNode) Hash() []byte {
+	return defaultBinaryEncoder.hash(&l)
+}
+
+func (l BinaryLeafNode) Raw() []interface{} {
+	return defaultBinaryEncoder.raw(&l)
+}
+
+func (b BinaryBranchNode) Hash() []byte {
+	return defaultBinaryEncoder.hash(&b)
+}
+
+func (b BinaryBranchNode) Raw() []interface{} {
+	return defaultBinaryEncoder.raw(&b)
+}
+
+// clone returns a copy of b that shares its children
+func (b *BinaryBranchNode) clone() *BinaryBranchNode {
+	c := *b
+	return &c
+}
+
+func (e BinaryExtensionNode) Hash() []byte {
+	return defaultBinaryEncoder.hash(&e)
+}
+
+func (e BinaryExtensionNode) Raw() []interface{} {
+	return defaultBinaryEncoder.raw(&e)
+}
+
+// binaryEncoder serializes binary nodes with RLP. Children are always
+// referenced by their hash, never embedded, so every proof step has the same
+// shape.
+type binaryEncoder struct {
+	hasher Hasher
+}
+
+var defaultBinaryEncoder = binaryEncoder{hasher: Keccak256}
+
+func (e binaryEncoder) raw(n Node) []interface{} {
+	switch node := n.(type) {
+	case *BinaryLeafNode:
+		return []interface{}{ToPrefixedBits(node.Path, true), node.Value}
+	case *BinaryBranchNode:
+		return []interface{}{e.reference(node.Children[0]), e.reference(node.Children[1]), node.Value}
+	case *BinaryExtensionNode:
+		return []interface{}{ToPrefixedBits(node.Path, false), e.reference(node.Next)}
+	}
+
+	panic(fmt.Sprintf("binary trie: cannot encode %T", n))
+}
+
+func (e binaryEncoder) serialize(n Node) []byte {
+	var raw interface{} = EmptyNodeRaw
+	if n != nil {
+		raw = e.raw(n)
+	}
+
+	b, err := rlp.EncodeToBytes(raw)
+	if err != nil {
+		panic(err)
+	}
+
+	return b
+}
+
+func (e binaryEncoder) hash(n Node) []byte {
+	if hash, ok := n.(HashNode); ok {
+		return hash
+	}
+
+	return e.hasher.Hash(e.serialize(n))
+}
+
+// reference is the hash of n, or nothing for a missing child
+func (e binaryEncoder) reference(n Node) []byte {
+	if n == nil {
+		return EmptyNodeRaw
+	}
+
+	return e.hash(n)
+}
+
+// decode rebuilds a binary node, its children are returned as HashNode
+func (e binaryEncoder) decode(b []byte) (Node, error) {
+	var items [][]byte
+	if err := rlp.DecodeBytes(b, &items); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidNode, err)
+	}
+
+	switch len(items) {
+	case 2:
+		path, isLeaf, ok := FromPrefixedBits(items[0])
+		if !ok {
+			return nil, fmt.Errorf("%w: invalid bit path", ErrInvalidNode)
+		}
+
+		if isLeaf {
+			return &BinaryLeafNode{Path: path, Value: items[1]}, nil
+		}
+
+		next, err := decodeBinaryReference(items[1])
+		if err != nil {
+			return nil, err
+		}
+
+		if next == nil || len(path) == 0 {
+			return nil, fmt.Errorf("%w: invalid extension", ErrInvalidNode)
+		}
+
+		return &BinaryExtensionNode{Path: path, Next: next}, nil
+	case 3:
+		branch := &BinaryBranchNode{}
+		for i := range branch.Children {
+			child, err := decodeBinaryReference(items[i])
+			if err != nil {
+				return nil, err
+			}
+
+			branch.Children[i] = child
+		}
+
+		if len(items[2]) > 0 {
+			branch.Value = items[2]
+		}
+
+		return branch, nil
+	}
+
+	return nil, fmt.Errorf("%w: list with %d items", ErrInvalidNode, len(items))
+}
+
+func decodeBinaryReference(b []byte) (Node, error) {
+	switch len(b) {
+	case 0:
+		return nil, nil
+	case 32:
+		return HashNode(b), nil
+	}
+
+	return nil, fmt.Errorf("%w: child reference with %d bytes", ErrInvalidNode, len(b))
+}
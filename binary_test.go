@@ -0,0 +1,133 @@
+package mptrie
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBits_ShouldRoundTrip(t *testing.T) {
+	require.Equal(t, []Bit{1, 0, 1, 0, 0, 0, 0, 1}, BitsFromBytes([]byte{0xa1}))
+	require.Equal(t, []byte{0xa1, 0x80}, BitsToBytes([]Bit{1, 0, 1, 0, 0, 0, 0, 1, 1}))
+
+	for _, bits := range [][]Bit{{}, {1}, {0, 1, 1}, BitsFromBytes([]byte{0xff, 0x01})} {
+		for _, leaf := range []bool{true, false} {
+			decoded, isLeaf, ok := FromPrefixedBits(ToPrefixedBits(bits, leaf))
+			require.True(t, ok)
+			require.Equal(t, leaf, isLeaf)
+			require.Equal(t, bits, decoded)
+		}
+	}
+}
+
+func TestBinaryTrie_ShouldMatchAMap(t *testing.T) {
+	r := rand.New(rand.NewSource(36))
+	trie := NewBinaryTrie()
+	expected := map[string][]byte{}
+
+	for i := 0; i < 2000; i++ {
+		key := randomKeys(r, 1)[0]
+
+		if r.Intn(3) == 0 {
+			require.NoError(t, trie.Delete(key))
+			delete(expected, string(key))
+			continue
+		}
+
+		value := []byte{byte(i), byte(i >> 8)}
+		require.NoError(t, trie.Put(key, value))
+		expected[string(key)] = value
+	}
+
+	for k, v := range expected {
+		got, ok := trie.Get([]byte(k))
+		require.True(t, ok)
+		require.Equal(t, v, got)
+	}
+
+	// the shape only depends on the content, not on the history
+	rebuilt := NewBinaryTrie()
+	for k, v := range expected {
+		require.NoError(t, rebuilt.Put([]byte(k), v))
+	}
+
+	require.Equal(t, rebuilt.Hash(), trie.Hash())
+
+	for k := range expected {
+		require.NoError(t, trie.Delete([]byte(k)))
+	}
+
+	require.Equal(t, NewBinaryTrie().Hash(), trie.Hash())
+	require.Nil(t, trie.root)
+}
+
+func TestBinaryTrie_Proof(t *testing.T) {
+	trie := NewBinaryTrie(WithHasher(SHA256))
+	for _, k := range routeKeys {
+		require.NoError(t, trie.Put([]byte(k), []byte("value of "+k)))
+	}
+
+	for _, k := range routeKeys {
+		proof := NewInMemoryStorage()
+		require.NoError(t, trie.CreateProof([]byte(k), proof))
+
+		value, err := VerifyBinaryProof(trie.Hash(), []byte(k), proof, WithHasher(SHA256))
+		require.NoError(t, err)
+		require.Equal(t, []byte("value of "+k), value)
+	}
+
+	require.ErrorIs(t, trie.CreateProof([]byte("network"), NewInMemoryStorage()), KeyNotFound)
+
+	proof := NewInMemoryStorage()
+	require.NoError(t, trie.CreateProof([]byte("service"), proof))
+
+	// the proof does not hold the nodes of another stored key
+	_, err := VerifyBinaryProof(trie.Hash(), []byte("service/eu"), proof, WithHasher(SHA256))
+	require.Error(t, err)
+
+	// the leaf of a key proves the keys it would lead to are absent
+	leaf := NewInMemoryStorage()
+	require.NoError(t, trie.CreateProof([]byte("storage/eu"), leaf))
+
+	value, err := VerifyBinaryProof(trie.Hash(), []byte("storage/eu/host-01"), leaf, WithHasher(SHA256))
+	require.NoError(t, err)
+	require.Nil(t, value)
+
+	value, err = VerifyBinaryProof(NewBinaryTrie(WithHasher(SHA256)).Hash(), []byte("service"), NewInMemoryStorage(), WithHasher(SHA256))
+	require.NoError(t, err)
+	require.Nil(t, value)
+
+	// a proof checked with another hasher does not match the root
+	_, err = VerifyBinaryProof(trie.Hash(), []byte("service"), proof)
+	require.Error(t, err)
+}
+
+func TestMerkleTrie_ShouldPickTheRadix(t *testing.T) {
+	roots := map[int][]byte{}
+
+	for _, radix := range []int{16, 2} {
+		trie, err := NewMerkleTrie(radix)
+		require.NoError(t, err)
+
+		for _, k := range routeKeys {
+			require.NoError(t, trie.Put([]byte(k), []byte("value of "+k)))
+		}
+
+		require.NoError(t, trie.Delete([]byte("service")))
+		_, ok := trie.Get([]byte("service"))
+		require.False(t, ok)
+
+		value, ok := trie.Get([]byte("storage/eu"))
+		require.True(t, ok)
+		require.Equal(t, []byte("value of storage/eu"), value)
+
+		require.NoError(t, trie.CreateProof([]byte("storage/eu"), NewInMemoryStorage()))
+		roots[radix] = trie.Hash()
+	}
+
+	require.NotEqual(t, roots[16], roots[2])
+
+	_, err := NewMerkleTrie(4)
+	require.Error(t, err)
+}
@@ -0,0 +1,80 @@
+package mptrie
+
+// Bit is a step in the path of a BinaryTrie, either 0 or 1
+type Bit byte
+
+// BitsFromBytes returns the bits of bs, most significant bit first
+func BitsFromBytes(bs []byte) []Bit {
+	bits := make([]Bit, 0, len(bs)*8)
+
+	for _, b := range bs {
+		for i := 7; i >= 0; i-- {
+			bits = append(bits, Bit(b>>uint(i)&1))
+		}
+	}
+
+	return bits
+}
+
+// BitsToBytes packs bits 8 per byte, the last byte is padded with zeros
+func BitsToBytes(bits []Bit) []byte {
+	buf := make([]byte, (len(bits)+7)/8)
+
+	for i, b := range bits {
+		buf[i/8] |= byte(b) << uint(7-i%8)
+	}
+
+	return buf
+}
+
+// ToPrefixedBits packs bits into bytes after a byte telling whether the path
+// belongs to a leaf node, in its highest bit, and how many bits of the last
+// byte are padding, in its 3 lowest bits.
+func ToPrefixedBits(bits []Bit, isLeafNode bool) []byte {
+	prefix := byte((8 - len(bits)%8) % 8)
+	if isLeafNode {
+		prefix |= 0x80
+	}
+
+	return append([]byte{prefix}, BitsToBytes(bits)...)
+}
+
+// FromPrefixedBits reverses ToPrefixedBits
+func FromPrefixedBits(b []byte) (bits []Bit, isLeafNode bool, ok bool) {
+	if len(b) == 0 || b[0]&0x78 != 0 {
+		return nil, false, false
+	}
+
+	padding := int(b[0] & 0x07)
+	bits = BitsFromBytes(b[1:])
+	if padding > len(bits) {
+		return nil, false, false
+	}
+
+	return bits[:len(bits)-padding], b[0]&0x80 != 0, true
+}
+
+// PrefixMatchedBits returns how many bits a and b have in common from the start
+func PrefixMatchedBits(a, b []Bit) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+
+	return i
+}
+
+// ConcatBits returns a new slice with the bits of every path in order
+func ConcatBits(paths ...[]Bit) []Bit {
+	size := 0
+	for _, p := range paths {
+		size += len(p)
+	}
+
+	bits := make([]Bit, 0, size)
+	for _, p := range paths {
+		bits = append(bits, p...)
+	}
+
+	return bits
+}
@@ -1,10 +1,16 @@
 package mptrie
 
-import "github.com/ethereum/go-ethereum/crypto"
-
 type BranchNode struct {
 	Branches [16]Node
 	Value    []byte
+
+	// ValueHash is set instead of Value when the node was decoded without
+	// its value, see WithHashedValues
+	ValueHash []byte
+
+	// Count is the number of keys below the node, only kept up to date
+	// when the trie is created WithCounts
+	Count int
 }
 
 func NewBranchNode() *BranchNode {
@@ -13,41 +19,33 @@ func NewBranchNode() *BranchNode {
 	}
 }
 
+// clone returns a copy of b that shares its children
+func (b *BranchNode) clone() *BranchNode {
+	c := *b
+	return &c
+}
+
 func (b *BranchNode) SetBranch(nb Nibble, n Node) {
 	b.Branches[int(nb)] = n
 }
 
 func (b *BranchNode) SetValue(v []byte) {
 	b.Value = v
+	b.ValueHash = nil
 }
 
 func (b BranchNode) Raw() []interface{} {
-	hashes := make([]interface{}, 17)
-	for i := 0; i < 16; i++ {
-		if b.Branches[i] == nil {
-			hashes[i] = EmptyNodeRaw
-		} else {
-			node := b.Branches[i]
-			if len(Serialize(node)) >= 32 {
-				hashes[i] = node.Hash()
-			} else {
-				hashes[i] = node.Raw()
-			}
-		}
-	}
-
-	hashes[16] = b.Value
-	return hashes
+	return defaultEncoder.raw(&b)
 }
 
 func (b BranchNode) Hash() []byte {
-	return crypto.Keccak256(b.Serialize())
+	return defaultEncoder.hash(&b)
 }
 
 func (b BranchNode) Serialize() []byte {
-	return Serialize(b)
+	return defaultEncoder.serialize(&b)
 }
 
 func (b BranchNode) HasValue() bool {
-	return b.Value != nil
+	return b.Value != nil || b.ValueHash != nil
 }
@@ -0,0 +1,122 @@
+package mptrie
+
+import (
+	"container/list"
+	"sync"
+)
+
+// NodeCache keeps the nodes decoded by tries opened from storage, keyed by
+// hash, so the nodes read often are not loaded and decoded again. It holds
+// up to a number of bytes of encoded nodes and drops the least recently used
+// ones first. A cache can be shared by several tries with the same options,
+// see WithNodeCache.
+type NodeCache struct {
+	limit int
+
+	lock    sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	// recent has the most recently used entries at the front
+	recent *list.List
+	stats  CacheStats
+}
+
+// CacheStats counts how the lookups of a NodeCache went
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+}
+
+type cacheEntry struct {
+	hash string
+	node Node
+	size int
+}
+
+// NewNodeCache returns an empty cache holding up to limit bytes
+func NewNodeCache(limit int) *NodeCache {
+	return &NodeCache{
+		limit:   limit,
+		entries: make(map[string]*list.Element),
+		recent:  list.New(),
+	}
+}
+
+// WithNodeCache keeps the nodes loaded from storage in c, nodes are never
+// changed once decoded so tries can share them.
+func WithNodeCache(c *NodeCache) Option {
+	return func(t *Trie) {
+		t.cache = c
+	}
+}
+
+// Get returns the node with the given hash
+func (c *NodeCache) Get(hash []byte) (Node, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	elem, ok := c.entries[string(hash)]
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+
+	c.stats.Hits++
+	c.recent.MoveToFront(elem)
+	return elem.Value.(*cacheEntry).node, true
+}
+
+// Add keeps n, whose encoding takes size bytes, under hash and evicts the
+// least recently used nodes until the cache is within its limit again
+func (c *NodeCache) Add(hash []byte, n Node, size int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if elem, ok := c.entries[string(hash)]; ok {
+		c.recent.MoveToFront(elem)
+		return
+	}
+
+	size += len(hash)
+	if size > c.limit {
+		return
+	}
+
+	entry := &cacheEntry{hash: string(hash), node: n, size: size}
+	c.entries[entry.hash] = c.recent.PushFront(entry)
+	c.size += size
+
+	for c.size > c.limit {
+		oldest := c.recent.Back()
+		evicted := oldest.Value.(*cacheEntry)
+
+		c.recent.Remove(oldest)
+		delete(c.entries, evicted.hash)
+		c.size -= evicted.size
+		c.stats.Evictions++
+	}
+}
+
+// Len returns the number of nodes in the cache
+func (c *NodeCache) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return len(c.entries)
+}
+
+// Size returns the bytes taken by the nodes in the cache
+func (c *NodeCache) Size() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.size
+}
+
+func (c *NodeCache) Stats() CacheStats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.stats
+}
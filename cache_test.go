@@ -0,0 +1,93 @@
+package mptrie
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNodeCache_ShouldBeSharedBetweenTries(t *testing.T) {
+	db := NewInMemoryStorage()
+	trie, roots := commitVersions(t, db, 3)
+	root := roots[2]
+
+	reader := &countingReader{NodeReader: db}
+	cache := NewNodeCache(1 << 20)
+
+	requireTrieContent(t, trie, root, reader, WithNodeCache(cache))
+	loaded := reader.loads
+	require.Greater(t, loaded, 0)
+
+	stats := cache.Stats()
+	require.Equal(t, uint64(loaded), stats.Misses)
+	require.Greater(t, stats.Hits, uint64(0))
+	require.Zero(t, stats.Evictions)
+	require.Equal(t, loaded, cache.Len())
+
+	// a second trie is served from the cache only
+	requireTrieContent(t, trie, root, reader, WithNodeCache(cache))
+	require.Equal(t, loaded, reader.loads)
+	require.Equal(t, stats.Misses, cache.Stats().Misses)
+
+	// changes to a trie do not reach the nodes in the cache
+	changed := OpenTrie(root, reader, WithNodeCache(cache))
+	require.NoError(t, changed.Put([]byte("account-001"), []byte("changed")))
+	requireTrieContent(t, trie, root, reader, WithNodeCache(cache))
+}
+
+func TestNodeCache_ShouldEvictLeastRecentlyUsed(t *testing.T) {
+	db := NewInMemoryStorage()
+	trie, roots := commitVersions(t, db, 3)
+	root := roots[2]
+
+	cache := NewNodeCache(2048)
+	requireTrieContent(t, trie, root, db, WithNodeCache(cache))
+
+	require.LessOrEqual(t, cache.Size(), 2048)
+	require.Greater(t, cache.Stats().Evictions, uint64(0))
+
+	// the root is read by every lookup, so it is never evicted
+	_, ok := cache.Get(root)
+	require.True(t, ok)
+
+	cache.Add([]byte("big"), nil, 4096)
+	_, ok = cache.Get([]byte("big"))
+	require.False(t, ok)
+}
+
+func TestNodeCache_ShouldBeSafeForConcurrentTries(t *testing.T) {
+	db := NewInMemoryStorage()
+	trie, roots := commitVersions(t, db, 2)
+	cache := NewNodeCache(4096)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			opened := OpenTrie(roots[1], db, WithNodeCache(cache))
+			for j := 0; j < 300; j++ {
+				key := []byte(fmt.Sprintf("account-%03d", (i*37+j)%300))
+				want, _ := trie.Get(key)
+
+				got, _, err := opened.TryGet(key)
+				if err != nil || string(got) != string(want) {
+					errs <- fmt.Errorf("read %s: %q %v", key, got, err)
+					return
+				}
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		require.NoError(t, err)
+	}
+}
@@ -0,0 +1,170 @@
+package mptrie
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+var (
+	ErrPreRootMismatch  = errors.New("trie root does not match the change set pre root")
+	ErrPostRootMismatch = errors.New("resulting root does not match the change set post root")
+)
+
+// ChangeSet holds the changes, in key order, that take a trie with root
+// PreRoot into a trie with root PostRoot. Added and modified keys are applied
+// as puts, removed keys as deletes.
+type ChangeSet struct {
+	PreRoot  []byte
+	PostRoot []byte
+	Changes  []Change
+}
+
+// NewChangeSet builds the change set that turns from into to
+func NewChangeSet(from, to *Trie) (*ChangeSet, error) {
+	cs := &ChangeSet{
+		PreRoot:  from.Hash(),
+		PostRoot: to.Hash(),
+	}
+
+	err := Diff(from, to, func(c Change) error {
+		cs.Changes = append(cs.Changes, c)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return cs, nil
+}
+
+// Apply checks that t is at PreRoot, applies every change and confirms the
+// result is PostRoot. The changes are applied to a copy of t, so t is left
+// at PreRoot when a change fails or the result does not match.
+func (cs *ChangeSet) Apply(t *Trie) error {
+	if !bytes.Equal(t.Hash(), cs.PreRoot) {
+		return ErrPreRootMismatch
+	}
+
+	c := t.Copy()
+	if err := applyChanges(c, cs.Changes); err != nil {
+		return err
+	}
+
+	if !bytes.Equal(c.Hash(), cs.PostRoot) {
+		return ErrPostRootMismatch
+	}
+
+	*t = *c
+	return nil
+}
+
+// Reverse returns the change set that takes a trie from PostRoot back to
+// PreRoot, used to roll back an applied change set.
+func (cs *ChangeSet) Reverse() *ChangeSet {
+	reversed := &ChangeSet{
+		PreRoot:  cs.PostRoot,
+		PostRoot: cs.PreRoot,
+		Changes:  make([]Change, len(cs.Changes)),
+	}
+
+	for i, c := range cs.Changes {
+		r := Change{Key: c.Key, From: c.To, To: c.From, Kind: Modified}
+
+		switch c.Kind {
+		case Added:
+			r.Kind = Removed
+		case Removed:
+			r.Kind = Added
+		}
+
+		reversed.Changes[len(cs.Changes)-1-i] = r
+	}
+
+	return reversed
+}
+
+func applyChanges(t *Trie, changes []Change) error {
+	for _, c := range changes {
+		var err error
+
+		switch c.Kind {
+		case Added, Modified:
+			err = t.Put(c.Key, c.To)
+		case Removed:
+			err = t.Delete(c.Key)
+		default:
+			err = fmt.Errorf("unknown change kind %d", c.Kind)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// encodedChange is the RLP form of a Change, RLP has no signed integers
+type encodedChange struct {
+	Kind uint8
+	Key  []byte
+	From []byte
+	To   []byte
+}
+
+type encodedChangeSet struct {
+	PreRoot  []byte
+	PostRoot []byte
+	Changes  []encodedChange
+}
+
+// Encode returns the binary (RLP) form of the change set
+func (cs *ChangeSet) Encode() ([]byte, error) {
+	enc := encodedChangeSet{
+		PreRoot:  cs.PreRoot,
+		PostRoot: cs.PostRoot,
+		Changes:  make([]encodedChange, len(cs.Changes)),
+	}
+
+	for i, c := range cs.Changes {
+		enc.Changes[i] = encodedChange{Kind: uint8(c.Kind), Key: c.Key, From: c.From, To: c.To}
+	}
+
+	return rlp.EncodeToBytes(enc)
+}
+
+// DecodeChangeSet rebuilds a change set from the output of Encode
+func DecodeChangeSet(b []byte) (*ChangeSet, error) {
+	var enc encodedChangeSet
+	if err := rlp.DecodeBytes(b, &enc); err != nil {
+		return nil, err
+	}
+
+	cs := &ChangeSet{
+		PreRoot:  enc.PreRoot,
+		PostRoot: enc.PostRoot,
+		Changes:  make([]Change, len(enc.Changes)),
+	}
+
+	for i, c := range enc.Changes {
+		change := Change{Kind: ChangeKind(c.Kind), Key: c.Key, From: c.From, To: c.To}
+
+		switch change.Kind {
+		case Added:
+			change.From = nil
+		case Removed:
+			change.To = nil
+		case Modified:
+		default:
+			return nil, fmt.Errorf("unknown change kind %d", c.Kind)
+		}
+
+		cs.Changes[i] = change
+	}
+
+	return cs, nil
+}
@@ -0,0 +1,103 @@
+package mptrie
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func changedTries(t *testing.T) (*Trie, *Trie) {
+	before := buildTrie(t, namespacedKeys)
+	after := buildTrie(t, namespacedKeys)
+
+	require.NoError(t, after.Delete([]byte("accounts.nonce")))
+	require.NoError(t, after.Put([]byte("accounts.code"), []byte("code")))
+	require.NoError(t, after.Put([]byte("block.number"), []byte("2")))
+
+	return before, after
+}
+
+func TestChangeSet_ShouldMoveReplicaToPostRoot(t *testing.T) {
+	before, after := changedTries(t)
+
+	cs, err := NewChangeSet(before, after)
+	require.NoError(t, err)
+	require.Len(t, cs.Changes, 3)
+
+	replica := buildTrie(t, namespacedKeys)
+	require.NoError(t, cs.Apply(replica))
+	require.Equal(t, after.Hash(), replica.Hash())
+
+	// applying it twice must fail as the replica is no longer at the pre root
+	require.ErrorIs(t, cs.Apply(replica), ErrPreRootMismatch)
+}
+
+func TestChangeSet_ReverseShouldRollBack(t *testing.T) {
+	before, after := changedTries(t)
+
+	cs, err := NewChangeSet(before, after)
+	require.NoError(t, err)
+
+	replica := buildTrie(t, namespacedKeys)
+	require.NoError(t, cs.Apply(replica))
+	require.NoError(t, cs.Reverse().Apply(replica))
+	require.Equal(t, before.Hash(), replica.Hash())
+}
+
+func TestChangeSet_ShouldUndoWhenPostRootDoesNotMatch(t *testing.T) {
+	before, after := changedTries(t)
+
+	cs, err := NewChangeSet(before, after)
+	require.NoError(t, err)
+
+	cs.Changes = cs.Changes[1:]
+
+	replica := buildTrie(t, namespacedKeys)
+	require.ErrorIs(t, cs.Apply(replica), ErrPostRootMismatch)
+	require.Equal(t, before.Hash(), replica.Hash())
+}
+
+func TestChangeSet_ShouldLeaveTheTrieWhenApplyFails(t *testing.T) {
+	replica := buildTrie(t, namespacedKeys)
+	root := replica.Hash()
+
+	// the key is said to be added while the replica already holds it
+	cs := &ChangeSet{
+		PreRoot:  root,
+		PostRoot: []byte("bogus"),
+		Changes:  []Change{{Key: []byte("accounts.nonce"), To: []byte("v"), Kind: Added}},
+	}
+
+	require.ErrorIs(t, cs.Apply(replica), ErrPostRootMismatch)
+	require.Equal(t, root, replica.Hash())
+
+	v, ok := replica.Get([]byte("accounts.nonce"))
+	require.True(t, ok)
+	require.Equal(t, []byte("value of accounts.nonce"), v)
+
+	// a change that cannot be applied after others were
+	cs.Changes = append(cs.Changes, Change{Key: []byte("block.number"), Kind: ChangeKind(42)})
+	require.Error(t, cs.Apply(replica))
+	require.Equal(t, root, replica.Hash())
+}
+
+func TestChangeSet_EncodeAndDecode(t *testing.T) {
+	before, after := changedTries(t)
+
+	cs, err := NewChangeSet(before, after)
+	require.NoError(t, err)
+
+	encoded, err := cs.Encode()
+	require.NoError(t, err)
+
+	decoded, err := DecodeChangeSet(encoded)
+	require.NoError(t, err)
+	require.Equal(t, cs, decoded)
+
+	replica := buildTrie(t, namespacedKeys)
+	require.NoError(t, decoded.Apply(replica))
+	require.Equal(t, after.Hash(), replica.Hash())
+
+	_, err = DecodeChangeSet(encoded[:len(encoded)-1])
+	require.Error(t, err)
+}
@@ -0,0 +1,124 @@
+package mptrie
+
+import (
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// ChildReference returns how a parent refers to child: by its hash when
+// hashed is true, or embedding its whole encoding otherwise.
+type ChildReference func(child Node) (ref []byte, hashed bool)
+
+// ValueReference returns how a node refers to its value: by its hash when
+// hashed is true, or holding the value itself otherwise.
+type ValueReference func(value []byte) (ref []byte, hashed bool)
+
+// NodeCodec defines the binary layout of the nodes. Encode must handle a nil
+// node, which is how an empty trie is encoded, and Decode must return the
+// children referenced by hash as HashNode and the values referenced by hash
+// in the ValueHash field of the nodes.
+type NodeCodec interface {
+	Encode(n Node, ref ChildReference, value ValueReference) []byte
+	Decode(b []byte) (Node, error)
+}
+
+var (
+	// RLPCodec is the default codec, the Ethereum node layout
+	RLPCodec NodeCodec = rlpCodec{}
+)
+
+// WithCodec builds the trie encoding its nodes with c instead of RLPCodec
+func WithCodec(c NodeCodec) Option {
+	return func(t *Trie) {
+		t.codec = c
+	}
+}
+
+type rlpCodec struct {
+	// counts appends the number of keys below branch and extension nodes
+	counts bool
+}
+
+func (c rlpCodec) Encode(n Node, ref ChildReference, value ValueReference) []byte {
+	var raw interface{} = EmptyNodeRaw
+	if n != nil {
+		raw = c.raw(n, ref, value)
+	}
+
+	b, err := rlp.EncodeToBytes(raw)
+	if err != nil {
+		panic(err)
+	}
+
+	return b
+}
+
+func (c rlpCodec) Decode(b []byte) (Node, error) {
+	return DecodeNode(b)
+}
+
+// raw builds the list RLP encodes for n, embedded children are inserted with
+// their encoding as it is and values referenced by hash as a list holding the
+// hash, since a plain value is always a string
+func (c rlpCodec) raw(n Node, ref ChildReference, value ValueReference) []interface{} {
+	switch node := n.(type) {
+	case *LeafNode:
+		return []interface{}{ToBytes(ToPrefixed(node.Path, true)), c.value(node.Value, node.ValueHash, value)}
+	case *BranchNode:
+		raw := make([]interface{}, 17, 18)
+		for i, child := range node.Branches {
+			if child == nil {
+				raw[i] = EmptyNodeRaw
+			} else {
+				raw[i] = c.reference(child, ref)
+			}
+		}
+
+		raw[16] = c.value(node.Value, node.ValueHash, value)
+		if c.counts {
+			raw = append(raw, uint64(node.Count))
+		}
+
+		return raw
+	case *ExtensionNode:
+		raw := []interface{}{ToBytes(ToPrefixed(node.Path, false)), c.reference(node.Next, ref)}
+		if c.counts {
+			raw = append(raw, uint64(node.Count))
+		}
+
+		return raw
+	}
+
+	return n.Raw()
+}
+
+func (c rlpCodec) reference(child Node, ref ChildReference) interface{} {
+	b, hashed := ref(child)
+	if hashed {
+		return b
+	}
+
+	return rlp.RawValue(b)
+}
+
+func (c rlpCodec) value(v, hash []byte, ref ValueReference) interface{} {
+	b, hashed := valueReference(v, hash, ref)
+	if hashed {
+		return []interface{}{b}
+	}
+
+	return b
+}
+
+// valueReference returns how a node refers to its value v, a node decoded
+// without its value only knows its hash
+func valueReference(v, hash []byte, ref ValueReference) ([]byte, bool) {
+	if v == nil && hash != nil {
+		return hash, true
+	}
+
+	if ref == nil || v == nil {
+		return v, false
+	}
+
+	return ref(v)
+}
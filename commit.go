@@ -0,0 +1,179 @@
+package mptrie
+
+import (
+	"bytes"
+	"errors"
+)
+
+var (
+	ErrMissingStorage = errors.New("trie has nodes in storage but no reader")
+)
+
+// OpenTrie returns the trie with the given root hash whose nodes were written
+// to db by Commit, opts must match the ones the trie was built with. Nodes are
+// only loaded from db when an operation reaches them, the methods that cannot
+// return an error treat a node missing from storage as if the keys below it
+// did not exist. Only the counts committed WithCommittedCounts are stored,
+// with WithCounts alone the opened trie counts keys by visiting the nodes.
+func OpenTrie(root []byte, db NodeReader, opts ...Option) *Trie {
+	t := NewTrie(opts...)
+	t.db = db
+	t.counted = t.commitCounts
+
+	if len(root) > 0 && !bytes.Equal(root, t.encoder().emptyRoot()) {
+		t.root = HashNode(copyBytes(root))
+	}
+
+	return t
+}
+
+// Commit writes every node of the trie into w keyed by its hash and returns
+// the root hash, embedded nodes are written as part of their parents. Nodes
+// that were never loaded from storage are expected to already be in w. When
+// w is a Batcher the nodes are written in a single batch, so a failed commit
+// leaves nothing behind.
+func (t *Trie) Commit(w KVWriter) ([]byte, error) {
+	e := t.encoder()
+
+	if t.root == nil {
+		return e.emptyRoot(), nil
+	}
+
+	if hash, ok := t.root.(HashNode); ok {
+		return hash, nil
+	}
+
+	var hash []byte
+	err := writeBatch(w, func(w KVWriter) (err error) {
+		// values stored apart go with the nodes unless the trie has its own
+		// store, whose batch is then written before the one of the nodes
+		if t.values == nil {
+			hash, err = e.commitRoot(t.root, w, w)
+			return err
+		}
+
+		return writeBatch(t.values, func(values KVWriter) (err error) {
+			hash, err = e.commitRoot(t.root, w, values)
+			return err
+		})
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return hash, nil
+}
+
+// commitRoot commits root and its children, the root is always stored by its
+// hash, even when it is small enough to be embedded by a parent.
+func (e encoder) commitRoot(root Node, w, values KVWriter) ([]byte, error) {
+	encoded, err := e.commitChildren(root, w, values)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := e.hasher.Hash(encoded)
+	if err := w.Put(hash, encoded); err != nil {
+		return nil, err
+	}
+
+	return hash, nil
+}
+
+// commit stores n when it is referenced by hash and returns the way its
+// parent references it, each node is encoded only once. Values referenced by
+// hash are stored in values.
+func (e encoder) commit(n Node, w, values KVWriter) ([]byte, bool, error) {
+	if hash, ok := n.(HashNode); ok {
+		return hash, true, nil
+	}
+
+	encoded, err := e.commitChildren(n, w, values)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if !e.hashed(encoded) {
+		return encoded, false, nil
+	}
+
+	hash := e.hasher.Hash(encoded)
+	if err := w.Put(hash, encoded); err != nil {
+		return nil, false, err
+	}
+
+	return hash, true, nil
+}
+
+// commitChildren commits every child and value of n and returns the encoding
+// of n
+func (e encoder) commitChildren(n Node, w, values KVWriter) ([]byte, error) {
+	var err error
+
+	commitChild := func(child Node) ([]byte, bool) {
+		if err != nil {
+			return nil, false
+		}
+
+		ref, hashed, childErr := e.commit(child, w, values)
+		err = childErr
+		return ref, hashed
+	}
+
+	commitValue := func(value []byte) ([]byte, bool) {
+		ref, hashed := e.valueReference(value)
+		if hashed && err == nil {
+			if vw, ok := values.(ValueWriter); ok {
+				err = vw.PutValue(ref, value)
+			} else {
+				err = values.Put(ref, value)
+			}
+		}
+
+		return ref, hashed
+	}
+
+	encoded := e.codec.Encode(n, commitChild, commitValue)
+
+	return encoded, err
+}
+
+// resolve loads n from storage when it is a HashNode, any other node is
+// already in memory and is returned as it is.
+func (t Trie) resolve(n Node) (Node, error) {
+	hash, ok := n.(HashNode)
+	if !ok {
+		return n, nil
+	}
+
+	if t.cache != nil {
+		if cached, ok := t.cache.Get(hash); ok {
+			return cached, nil
+		}
+	}
+
+	if t.db == nil {
+		return nil, ErrMissingStorage
+	}
+
+	encoded, err := t.db.Get(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err = t.encoder().decode(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := t.loadValues(n); err != nil {
+		return nil, err
+	}
+
+	if t.cache != nil {
+		t.cache.Add(hash, n, len(encoded))
+	}
+
+	return n, nil
+}
@@ -0,0 +1,365 @@
+package mptrie
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+)
+
+// CompactionStats reports what a LogStorage.Compact run did
+type CompactionStats struct {
+	// LiveNodes is the number of nodes reachable from the roots
+	LiveNodes int
+	// BytesBefore is the size of the segments that were compacted and
+	// BytesAfter the size of the segments that replaced them
+	BytesBefore    int64
+	BytesAfter     int64
+	BytesReclaimed int64
+}
+
+// Compact is a mark-and-sweep garbage collection of the nodes of the tries
+// with the given roots: every node reachable from them, decoded with the
+// layout given by opts, is rewritten into new segments and every other key
+// written before the compaction started is dropped, so the storage must only
+// hold trie nodes and the values they reference by hash. opts must match the
+// ones the tries were built with. Readers and writers keep running during the
+// compaction, keys written meanwhile are always kept.
+func (s *LogStorage) Compact(roots [][]byte, opts ...Option) (CompactionStats, error) {
+	var stats CompactionStats
+
+	s.compactLock.Lock()
+	defer s.compactLock.Unlock()
+
+	sealed, first, last, err := s.seal()
+	if err != nil {
+		return stats, err
+	}
+
+	live, err := s.mark(roots, NewTrie(opts...).encoder())
+	if err != nil {
+		return stats, err
+	}
+
+	stats.LiveNodes = len(live)
+
+	// the live keys still stored in the sealed segments, the ones written
+	// again after sealing are already in newer segments
+	s.lock.RLock()
+	var keys [][]byte
+	for k, loc := range s.index {
+		if _, ok := sealed[loc.segment]; ok && live[k] {
+			keys = append(keys, []byte(k))
+		}
+	}
+
+	for _, seg := range sealed {
+		stats.BytesBefore += seg.size
+	}
+	s.lock.RUnlock()
+
+	compacted, locations, err := s.writeCompacted(first, last, keys)
+	if err != nil {
+		return stats, err
+	}
+
+	for _, seg := range compacted {
+		stats.BytesAfter += seg.size
+	}
+
+	stats.BytesReclaimed = stats.BytesBefore - stats.BytesAfter
+
+	// the new index is built while readers keep using the current one, only
+	// writers wait so no key changes until it is swapped in
+	s.writeLock.Lock()
+	s.lock.RLock()
+	index := make(map[string]logLocation, len(s.index))
+	for k, loc := range s.index {
+		if _, ok := sealed[loc.segment]; !ok {
+			index[k] = loc
+		} else if newLoc, ok := locations[k]; ok {
+			index[k] = newLoc
+		}
+	}
+	s.lock.RUnlock()
+
+	s.lock.Lock()
+	s.index = index
+	for _, seg := range compacted {
+		s.segments[seg.id] = seg
+	}
+
+	for id := range sealed {
+		delete(s.segments, id)
+	}
+	s.lock.Unlock()
+	s.writeLock.Unlock()
+
+	// no reader can reach the sealed segments anymore, they are removed
+	// oldest first so a crash never leaves a delete behind without the
+	// older write it undid
+	for _, id := range sortedSegmentIDs(sealed) {
+		seg := sealed[id]
+		if err := seg.file.Close(); err != nil {
+			return stats, err
+		}
+
+		if err := os.Remove(s.segmentPath(id)); err != nil {
+			return stats, err
+		}
+	}
+
+	return stats, syncDir(s.dir)
+}
+
+// seal moves the writes to a new segment and returns the segments written
+// until then. The ids from first to last, between them and the new segment,
+// are reserved for the compacted segments so they are replayed after the
+// sealed ones and before any newer write. There are enough of them for the
+// sealed bytes to be rewritten at the segment size.
+func (s *LogStorage) seal() (sealed map[int]*logSegment, first, last int, err error) {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	s.lock.RLock()
+	closed, active := s.closed, s.active
+
+	var size int64
+	for _, seg := range s.segments {
+		size += seg.size
+	}
+	reserved := len(s.segments) + int(size/s.segmentSize) + 1
+	s.lock.RUnlock()
+
+	if closed {
+		return nil, 0, 0, NotInitialized
+	}
+
+	next, err := s.createSegment(active.id + reserved + 1)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	sealed = make(map[int]*logSegment, len(s.segments))
+	for id, seg := range s.segments {
+		sealed[id] = seg
+	}
+
+	s.segments[next.id] = next
+	s.active = next
+
+	return sealed, active.id + 1, active.id + reserved, nil
+}
+
+// mark returns the keys of every node reachable from roots, along with the
+// values they reference by hash
+func (s *LogStorage) mark(roots [][]byte, e encoder) (map[string]bool, error) {
+	live := make(map[string]bool)
+	pending := make([][]byte, 0, len(roots))
+
+	empty := e.emptyRoot()
+	for _, root := range roots {
+		if len(root) > 0 && !bytes.Equal(root, empty) {
+			pending = append(pending, root)
+		}
+	}
+
+	for len(pending) > 0 {
+		hash := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		if live[string(hash)] {
+			continue
+		}
+
+		encoded, err := s.Get(hash)
+		if err != nil {
+			return nil, err
+		}
+
+		live[string(hash)] = true
+
+		n, err := e.decode(encoded)
+		if err != nil {
+			return nil, err
+		}
+
+		var values [][]byte
+		pending, values = appendReferences(pending, values, n)
+
+		// values are not nodes, they only need to exist
+		for _, value := range values {
+			if has, err := s.Has(value); err != nil || !has {
+				return nil, fmt.Errorf("missing value %x: %v", value, err)
+			}
+
+			live[string(value)] = true
+		}
+	}
+
+	return live, nil
+}
+
+// appendReferences adds the hashes of the nodes and of the values n refers
+// to, looking into its embedded children as well
+func appendReferences(nodes, values [][]byte, n Node) ([][]byte, [][]byte) {
+	switch node := n.(type) {
+	case HashNode:
+		nodes = append(nodes, node)
+	case *LeafNode:
+		if node.ValueHash != nil {
+			values = append(values, node.ValueHash)
+		}
+	case *BranchNode:
+		if node.ValueHash != nil {
+			values = append(values, node.ValueHash)
+		}
+
+		for _, child := range node.Branches {
+			nodes, values = appendReferences(nodes, values, child)
+		}
+	case *ExtensionNode:
+		nodes, values = appendReferences(nodes, values, node.Next)
+	}
+
+	return nodes, values
+}
+
+// writeCompacted copies the values of keys into segments with the ids from
+// first to last, starting a new segment once one reaches the segment size.
+// The last id takes whatever is left. Each file is written under a temporary
+// name and only renamed once synced. Values stored by hash never change, so
+// copying them outside of the locks is safe. Values that are not found
+// anymore were deleted meanwhile and are skipped.
+func (s *LogStorage) writeCompacted(first, last int, keys [][]byte) ([]*logSegment, map[string]logLocation, error) {
+	w := &compactWriter{db: s, next: first, last: last, locations: make(map[string]logLocation, len(keys))}
+
+	batch := &logBatch{db: s}
+	for _, key := range keys {
+		value, err := s.Get(key)
+		if errors.Is(err, KeyNotFound) {
+			continue
+		}
+
+		if err != nil {
+			w.abort()
+			return nil, nil, err
+		}
+
+		batch.Put(key, value)
+		if w.size+logHeaderSize+int64(len(batch.payload)) >= s.segmentSize {
+			if err := w.write(batch.payload); err != nil {
+				w.abort()
+				return nil, nil, err
+			}
+
+			batch.Reset()
+		}
+	}
+
+	if len(batch.payload) > 0 {
+		if err := w.write(batch.payload); err != nil {
+			w.abort()
+			return nil, nil, err
+		}
+	}
+
+	if err := w.finish(); err != nil {
+		w.abort()
+		return nil, nil, err
+	}
+
+	return w.segments, w.locations, nil
+}
+
+// compactWriter appends records to the compacted segments, only the one being
+// written is open under its temporary name
+type compactWriter struct {
+	db         *LogStorage
+	next, last int
+
+	file *os.File
+	size int64
+
+	segments  []*logSegment
+	locations map[string]logLocation
+}
+
+func (w *compactWriter) write(payload []byte) error {
+	if w.file == nil {
+		file, err := os.OpenFile(w.db.segmentPath(w.next)+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+		if err != nil {
+			return err
+		}
+
+		w.file, w.size = file, 0
+	}
+
+	var header [logHeaderSize]byte
+	binary.LittleEndian.PutUint32(header[0:4], crc32.ChecksumIEEE(payload))
+	binary.LittleEndian.PutUint32(header[4:8], uint32(len(payload)))
+
+	if _, err := w.file.Write(append(header[:], payload...)); err != nil {
+		return err
+	}
+
+	offset := w.size + logHeaderSize
+	walkLogPayload(payload, func(op byte, key []byte, valueAt, size int) {
+		w.locations[string(key)] = logLocation{segment: w.next, offset: offset + int64(valueAt), size: size}
+	})
+
+	w.size += logHeaderSize + int64(len(payload))
+	if w.size >= w.db.segmentSize && w.next < w.last {
+		return w.finish()
+	}
+
+	return nil
+}
+
+// finish syncs the segment being written and gives it its name
+func (w *compactWriter) finish() error {
+	if w.file == nil {
+		return nil
+	}
+
+	tmp, path := w.db.segmentPath(w.next)+".tmp", w.db.segmentPath(w.next)
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+
+	w.file = nil
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	w.segments = append(w.segments, &logSegment{id: w.next, file: file, size: w.size})
+	w.next++
+	return nil
+}
+
+// abort removes every segment written, none of them is in use yet
+func (w *compactWriter) abort() {
+	if w.file != nil {
+		w.file.Close()
+		os.Remove(w.db.segmentPath(w.next) + ".tmp")
+	}
+
+	for _, seg := range w.segments {
+		seg.file.Close()
+		os.Remove(w.db.segmentPath(seg.id))
+	}
+}
@@ -0,0 +1,228 @@
+package mptrie
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// commitVersions commits a trie after each round of changes and returns the
+// roots, every round rewrites the values of a third of the keys
+func commitVersions(t *testing.T, db KVWriter, rounds int) (*Trie, [][]byte) {
+	trie := NewTrie()
+	var roots [][]byte
+
+	for round := 0; round < rounds; round++ {
+		for i := round % 3; i < 300; i += 3 {
+			key := []byte(fmt.Sprintf("account-%03d", i))
+			require.NoError(t, trie.Put(key, []byte(fmt.Sprintf("balance %d at round %d", i, round))))
+		}
+
+		root, err := trie.Commit(db)
+		require.NoError(t, err)
+		roots = append(roots, root)
+	}
+
+	return trie, roots
+}
+
+func requireTrieContent(t *testing.T, expected *Trie, root []byte, db NodeReader, opts ...Option) {
+	opened := OpenTrie(root, db, opts...)
+	for i := 0; i < 300; i++ {
+		key := []byte(fmt.Sprintf("account-%03d", i))
+		want, _ := expected.Get(key)
+
+		got, ok, err := opened.TryGet(key)
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, want, got)
+	}
+}
+
+func TestLogStorage_CompactShouldKeepOnlyLiveNodes(t *testing.T) {
+	dir := t.TempDir()
+	s := openLogStorage(t, dir, WithSegmentSize(16<<10))
+
+	trie, roots := commitVersions(t, s, 6)
+	latest := roots[len(roots)-1]
+
+	stats, err := s.Compact([][]byte{latest, EmptyNodeHash})
+	require.NoError(t, err)
+	require.Greater(t, stats.LiveNodes, 0)
+	require.Greater(t, stats.BytesReclaimed, int64(0))
+	require.Equal(t, stats.BytesBefore-stats.BytesAfter, stats.BytesReclaimed)
+
+	requireTrieContent(t, trie, latest, s)
+
+	// the first version shares no value with the latest one anymore
+	_, _, err = OpenTrie(roots[0], s).TryGet([]byte("account-000"))
+	require.ErrorIs(t, err, KeyNotFound)
+
+	// a second run has nothing left to reclaim but the empty segment
+	again, err := s.Compact([][]byte{latest})
+	require.NoError(t, err)
+	require.Equal(t, stats.LiveNodes, again.LiveNodes)
+	require.Equal(t, stats.BytesAfter, again.BytesAfter)
+
+	require.NoError(t, s.Close())
+
+	s = openLogStorage(t, dir)
+	defer s.Close()
+
+	requireTrieContent(t, trie, latest, s)
+	require.Len(t, segmentFiles(t, dir), 2)
+}
+
+func TestLogStorage_CompactShouldNotBlockReadersAndWriters(t *testing.T) {
+	s := openLogStorage(t, t.TempDir())
+	defer s.Close()
+
+	trie, roots := commitVersions(t, s, 4)
+	latest := roots[len(roots)-1]
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	errs := make(chan error, 4)
+
+	for r := 0; r < 3; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				v, ok, err := OpenTrie(latest, s).TryGet([]byte("account-042"))
+				if err != nil || !ok {
+					errs <- fmt.Errorf("read failed: %v", err)
+					return
+				}
+
+				want, _ := trie.Get([]byte("account-042"))
+				if !bytes.Equal(want, v) {
+					errs <- fmt.Errorf("read %q", v)
+					return
+				}
+			}
+		}()
+	}
+
+	// a trie committed while the compaction runs is kept
+	next := trie.Copy()
+	require.NoError(t, next.Put([]byte("account-999"), []byte("new account")))
+
+	wg.Add(1)
+	var nextRoot []byte
+	go func() {
+		defer wg.Done()
+
+		root, err := next.Commit(s)
+		if err != nil {
+			errs <- err
+		}
+
+		nextRoot = root
+	}()
+
+	_, err := s.Compact([][]byte{latest})
+	require.NoError(t, err)
+
+	close(stop)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	v, ok, err := OpenTrie(nextRoot, s).TryGet([]byte("account-999"))
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, []byte("new account"), v)
+}
+
+func TestLogStorage_CompactShouldKeepHashedValues(t *testing.T) {
+	s := openLogStorage(t, t.TempDir())
+	defer s.Close()
+
+	big := bytes.Repeat([]byte{0x42}, 100)
+	trie := NewTrie(WithHashedValues(33, nil))
+	require.NoError(t, trie.Put([]byte("big"), big))
+	require.NoError(t, trie.Put([]byte("small"), []byte("1")))
+
+	root, err := trie.Commit(s)
+	require.NoError(t, err)
+
+	_, err = s.Compact([][]byte{root})
+	require.NoError(t, err)
+
+	v, ok, err := OpenTrie(root, s, WithHashedValues(33, nil)).TryGet([]byte("big"))
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, big, v)
+}
+
+func TestLogStorage_CompactShouldRespectSegmentSize(t *testing.T) {
+	dir := t.TempDir()
+	s := openLogStorage(t, dir, WithSegmentSize(2<<10))
+
+	trie, roots := commitVersions(t, s, 3)
+	latest := roots[len(roots)-1]
+
+	stats, err := s.Compact([][]byte{latest})
+	require.NoError(t, err)
+	require.Greater(t, stats.BytesAfter, int64(2<<10))
+
+	// the compacted segments plus the active one, none of them bigger than
+	// the segment size but for the record that crosses it
+	files := segmentFiles(t, dir)
+	require.Greater(t, len(files), 2)
+
+	for _, file := range files {
+		info, err := os.Stat(file)
+		require.NoError(t, err)
+		require.Less(t, info.Size(), int64(4<<10), file)
+	}
+
+	tmp, err := filepath.Glob(filepath.Join(dir, "*.tmp"))
+	require.NoError(t, err)
+	require.Empty(t, tmp)
+
+	requireTrieContent(t, trie, latest, s)
+	require.NoError(t, s.Close())
+
+	s = openLogStorage(t, dir, WithSegmentSize(2<<10))
+	defer s.Close()
+
+	requireTrieContent(t, trie, latest, s)
+}
+
+func TestLogStorage_CompactShouldUseTheTrieHasher(t *testing.T) {
+	s := openLogStorage(t, t.TempDir())
+	defer s.Close()
+
+	trie := NewTrie(WithHasher(Blake2b))
+	for i := 0; i < 300; i++ {
+		require.NoError(t, trie.Put([]byte(fmt.Sprintf("account-%03d", i)), []byte(fmt.Sprintf("balance %d", i))))
+	}
+
+	root, err := trie.Commit(s)
+	require.NoError(t, err)
+
+	// the empty root of the hasher has no node stored under it
+	empty := NewTrie(WithHasher(Blake2b)).Hash()
+	stats, err := s.Compact([][]byte{root, empty}, WithHasher(Blake2b))
+	require.NoError(t, err)
+	require.Greater(t, stats.LiveNodes, 0)
+
+	requireTrieContent(t, trie, root, s, WithHasher(Blake2b))
+}
@@ -0,0 +1,157 @@
+package mptrie
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// The fixtures in testdata/ethereum are the TrieTests of
+// github.com/ethereum/tests, see testdata/ethereum/README.md for the revision
+// they were copied from.
+
+func loadEthereumFixture(t *testing.T, name string, v interface{}) {
+	b, err := os.ReadFile(filepath.Join("testdata", "ethereum", name))
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(b, v))
+}
+
+// fixtureBytes decodes a fixture string, the ones starting with 0x are hex
+func fixtureBytes(t *testing.T, s string) []byte {
+	if !strings.HasPrefix(s, "0x") {
+		return []byte(s)
+	}
+
+	b, err := hex.DecodeString(s[2:])
+	require.NoError(t, err)
+	return b
+}
+
+// requireFixtureRoot checks the root of trie, and that the trie committed to
+// storage and opened again has the same root
+func requireFixtureRoot(t *testing.T, trie *Trie, root, name string) {
+	require.Equal(t, root, "0x"+hex.EncodeToString(trie.Hash()), name)
+
+	db := NewInMemoryStorage()
+	committed, err := trie.Commit(db)
+	require.NoError(t, err)
+	require.Equal(t, trie.Hash(), OpenTrie(committed, db).Hash(), name)
+}
+
+type anyOrderFixture map[string]struct {
+	In   map[string]string `json:"in"`
+	Root string            `json:"root"`
+}
+
+// runAnyOrder builds each trie of the fixture inserting its keys in sorted,
+// reversed and shuffled orders, keys are hashed first for secure tries
+func runAnyOrder(t *testing.T, fixtures anyOrderFixture, secure bool) {
+	r := rand.New(rand.NewSource(1))
+
+	for name, f := range fixtures {
+		keys := make([]string, 0, len(f.In))
+		for k := range f.In {
+			keys = append(keys, k)
+		}
+
+		sort.Strings(keys)
+
+		for order := 0; order < 5; order++ {
+			switch order {
+			case 0:
+			case 1:
+				sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+			default:
+				r.Shuffle(len(keys), func(i, j int) { keys[i], keys[j] = keys[j], keys[i] })
+			}
+
+			trie := NewTrie()
+			for _, k := range keys {
+				key := fixtureBytes(t, k)
+				if secure {
+					key = Keccak256.Hash(key)
+				}
+
+				require.NoError(t, trie.Put(key, fixtureBytes(t, f.In[k])), name)
+			}
+
+			requireFixtureRoot(t, trie, f.Root, name)
+		}
+	}
+}
+
+func TestEthereumFixtures_TrieTest(t *testing.T) {
+	var fixtures map[string]struct {
+		In   [][2]*string `json:"in"`
+		Root string       `json:"root"`
+	}
+
+	loadEthereumFixture(t, "trietest.json", &fixtures)
+	require.NotEmpty(t, fixtures)
+
+	for name, f := range fixtures {
+		trie := NewTrie()
+
+		// a null value deletes the key
+		for _, op := range f.In {
+			key := fixtureBytes(t, *op[0])
+			if op[1] == nil {
+				require.NoError(t, trie.Delete(key), name)
+			} else {
+				require.NoError(t, trie.Put(key, fixtureBytes(t, *op[1])), name)
+			}
+		}
+
+		requireFixtureRoot(t, trie, f.Root, name)
+	}
+}
+
+func TestEthereumFixtures_TrieAnyOrder(t *testing.T) {
+	var fixtures anyOrderFixture
+	loadEthereumFixture(t, "trieanyorder.json", &fixtures)
+	require.NotEmpty(t, fixtures)
+
+	runAnyOrder(t, fixtures, false)
+}
+
+func TestEthereumFixtures_HexEncodedSecureTrie(t *testing.T) {
+	var fixtures anyOrderFixture
+	loadEthereumFixture(t, "hex_encoded_securetrie_test.json", &fixtures)
+	require.NotEmpty(t, fixtures)
+
+	runAnyOrder(t, fixtures, true)
+}
+
+func TestEthereumFixtures_TrieTestNextPrev(t *testing.T) {
+	var fixtures map[string]struct {
+		In    []string    `json:"in"`
+		Tests [][3]string `json:"tests"`
+	}
+
+	loadEthereumFixture(t, "trietestnextprev.json", &fixtures)
+	require.NotEmpty(t, fixtures)
+
+	for name, f := range fixtures {
+		trie := NewTrie()
+		for _, k := range f.In {
+			require.NoError(t, trie.Put([]byte(k), []byte(k)))
+		}
+
+		// each test is a key followed by the keys right before and after
+		// it, an empty string when there is none
+		for _, test := range f.Tests {
+			prev, _, _ := trie.Predecessor([]byte(test[0]))
+			next, _, _ := trie.Successor([]byte(test[0]))
+
+			require.Equal(t, test[1], string(prev), "%s: before %q", name, test[0])
+			require.Equal(t, test[2], string(next), "%s: after %q", name, test[0])
+		}
+	}
+}
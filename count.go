@@ -0,0 +1,269 @@
+package mptrie
+
+// WithCounts keeps the number of keys below each branch and extension node
+// up to date on every change, so Len, Rank and Select don't need to visit the
+// whole trie. The counts are not part of the node hashes nor stored, tries
+// opened from storage with it count the keys by visiting the nodes.
+func WithCounts() Option {
+	return func(t *Trie) {
+		t.counted = true
+	}
+}
+
+// WithCommittedCounts works as WithCounts but also commits the counts into
+// the node hashes, the resulting root differs from a trie without counts.
+// Only tries built this way keep their counts once committed and opened
+// again with the same option.
+func WithCommittedCounts() Option {
+	return func(t *Trie) {
+		t.counted = true
+		t.commitCounts = true
+	}
+}
+
+// Len returns the number of keys stored in the trie
+func (t Trie) Len() int {
+	count, _ := t.count(t.root)
+	return count
+}
+
+// Rank returns how many keys in the trie are smaller than key
+func (t Trie) Rank(key []byte) int {
+	rank, _ := t.rank(t.root, nil, FromBytes(key))
+	return rank
+}
+
+// Select returns the key at position i (starting at 0) in the sorted order of
+// the trie keys, so Select(Rank(key)) returns key when it is stored.
+func (t Trie) Select(i int) (key, value []byte, ok bool) {
+	if i < 0 {
+		return nil, nil, false
+	}
+
+	n := t.root
+	var (
+		path []Nibble
+		err  error
+	)
+
+	for {
+		n, err = t.resolve(n)
+		if err != nil {
+			return nil, nil, false
+		}
+
+		if leaf, ok := n.(*LeafNode); ok {
+			if i > 0 {
+				return nil, nil, false
+			}
+
+			return toKey(ConcatNibbles(path, leaf.Path), leaf.Value, true)
+		}
+
+		if branch, ok := n.(*BranchNode); ok {
+			if branch.HasValue() {
+				if i == 0 {
+					return toKey(path, branch.Value, true)
+				}
+				i--
+			}
+
+			next := -1
+			for b := 0; b < 16; b++ {
+				c, err := t.count(branch.Branches[b])
+				if err != nil {
+					return nil, nil, false
+				}
+
+				if i < c {
+					next = b
+					break
+				}
+				i -= c
+			}
+
+			if next < 0 {
+				return nil, nil, false
+			}
+
+			path = ConcatNibbles(path, []Nibble{Nibble(next)})
+			n = branch.Branches[next]
+			continue
+		}
+
+		if ext, ok := n.(*ExtensionNode); ok {
+			path = ConcatNibbles(path, ext.Path)
+			n = ext.Next
+			continue
+		}
+
+		return nil, nil, false
+	}
+}
+
+func (t Trie) rank(n Node, path, target []Nibble) (int, error) {
+	if n == nil {
+		return 0, nil
+	}
+
+	// the target does not go through n, so either every key is smaller or none
+	if !HasNibblePrefix(target, path) {
+		if CompareNibbles(path, target) < 0 {
+			return t.count(n)
+		}
+
+		return 0, nil
+	}
+
+	n, err := t.resolve(n)
+	if err != nil {
+		return 0, err
+	}
+
+	switch node := n.(type) {
+	case *LeafNode:
+		if CompareNibbles(ConcatNibbles(path, node.Path), target) < 0 {
+			return 1, nil
+		}
+	case *BranchNode:
+		if len(target) == len(path) {
+			return 0, nil
+		}
+
+		smaller := 0
+		if node.HasValue() {
+			smaller++
+		}
+
+		nb := target[len(path)]
+		for i := 0; i < int(nb); i++ {
+			c, err := t.count(node.Branches[i])
+			if err != nil {
+				return 0, err
+			}
+
+			smaller += c
+		}
+
+		below, err := t.rank(node.Branches[nb], ConcatNibbles(path, []Nibble{nb}), target)
+		return smaller + below, err
+	case *ExtensionNode:
+		return t.rank(node.Next, ConcatNibbles(path, node.Path), target)
+	}
+
+	return 0, nil
+}
+
+// count returns the number of keys below n, reading the kept counts when the
+// trie has them or visiting the whole subtree otherwise
+func (t Trie) count(n Node) (int, error) {
+	if !t.counted {
+		return t.countKeys(n)
+	}
+
+	switch node := n.(type) {
+	case *LeafNode:
+		return 1, nil
+	case *BranchNode:
+		return node.Count, nil
+	case *ExtensionNode:
+		return node.Count, nil
+	case HashNode:
+		// nodes committed with their counts keep them when decoded
+		resolved, err := t.resolve(node)
+		if err != nil {
+			return 0, err
+		}
+
+		return t.count(resolved)
+	}
+
+	return 0, nil
+}
+
+// refreshCounts recomputes, from the bottom up, the counts of every node
+// along nibbles. Nodes out of that path are expected to be up to date, except
+// for extensions hanging from a branch on the path which are fixed as well.
+// Children still in storage are loaded to read their counts.
+func (t Trie) refreshCounts(nibbles []Nibble) error {
+	var visited []Node
+
+	n := t.root
+	for n != nil {
+		visited = append(visited, n)
+
+		if branch, ok := n.(*BranchNode); ok {
+			if len(nibbles) == 0 {
+				break
+			}
+
+			n, nibbles = branch.Branches[nibbles[0]], nibbles[1:]
+			continue
+		}
+
+		if ext, ok := n.(*ExtensionNode); ok {
+			if !HasNibblePrefix(nibbles, ext.Path) {
+				break
+			}
+
+			n, nibbles = ext.Next, nibbles[len(ext.Path):]
+			continue
+		}
+
+		break
+	}
+
+	for i := len(visited) - 1; i >= 0; i-- {
+		if _, err := t.refreshCount(visited[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// refreshCount recomputes the count of n from its direct children
+func (t Trie) refreshCount(n Node) (int, error) {
+	switch node := n.(type) {
+	case *LeafNode:
+		return 1, nil
+	case *BranchNode:
+		node.Count = 0
+		if node.HasValue() {
+			node.Count++
+		}
+
+		for _, child := range node.Branches {
+			c, err := t.childCount(child)
+			if err != nil {
+				return 0, err
+			}
+
+			node.Count += c
+		}
+
+		return node.Count, nil
+	case *ExtensionNode:
+		c, err := t.childCount(node.Next)
+		if err != nil {
+			return 0, err
+		}
+
+		node.Count = c
+		return node.Count, nil
+	}
+
+	return 0, nil
+}
+
+// childCount returns the count of a child of a node being refreshed
+func (t Trie) childCount(child Node) (int, error) {
+	switch c := child.(type) {
+	case *ExtensionNode:
+		return t.refreshCount(c)
+	case nil:
+		return 0, nil
+	}
+
+	return t.count(child)
+}
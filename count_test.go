@@ -0,0 +1,195 @@
+package mptrie
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func randomKeys(r *rand.Rand, n int) [][]byte {
+	keys := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		// short keys over a small alphabet so many of them share prefixes
+		key := make([]byte, 1+r.Intn(4))
+		for j := range key {
+			key[j] = byte('a' + r.Intn(3))
+		}
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+// requireCounts checks every kept count against a full visit of the subtree
+func requireCounts(t *testing.T, trie *Trie, n Node) {
+	switch node := n.(type) {
+	case *BranchNode:
+		count, err := trie.countKeys(node)
+		require.NoError(t, err)
+		require.Equal(t, count, node.Count)
+
+		for _, child := range node.Branches {
+			requireCounts(t, trie, child)
+		}
+	case *ExtensionNode:
+		count, err := trie.countKeys(node)
+		require.NoError(t, err)
+		require.Equal(t, count, node.Count)
+
+		requireCounts(t, trie, node.Next)
+	}
+}
+
+func requireOrderStatistics(t *testing.T, trie *Trie, stored map[string]bool) {
+	sorted := make([][]byte, 0, len(stored))
+	for k := range stored {
+		sorted = append(sorted, []byte(k))
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i], sorted[j]) < 0
+	})
+
+	require.Equal(t, len(sorted), trie.Len())
+
+	for i, k := range sorted {
+		require.Equal(t, i, trie.Rank(k))
+
+		key, _, ok := trie.Select(i)
+		require.True(t, ok)
+		require.Equal(t, k, key)
+	}
+
+	_, _, ok := trie.Select(len(sorted))
+	require.False(t, ok)
+}
+
+func TestCounts_ShouldFollowPutAndDelete(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	keys := randomKeys(r, 200)
+
+	trie := NewTrie(WithCounts())
+	stored := map[string]bool{}
+
+	for _, k := range keys {
+		require.NoError(t, trie.Put(k, []byte("value")))
+		stored[string(k)] = true
+	}
+
+	requireCounts(t, trie, trie.root)
+	requireOrderStatistics(t, trie, stored)
+
+	for _, k := range keys[:100] {
+		require.NoError(t, trie.Delete(k))
+		delete(stored, string(k))
+	}
+
+	requireCounts(t, trie, trie.root)
+	requireOrderStatistics(t, trie, stored)
+}
+
+func TestCounts_ShouldFollowBulkDeletes(t *testing.T) {
+	trie := NewTrie(WithCounts())
+	for _, k := range namespacedKeys {
+		require.NoError(t, trie.Put([]byte(k), []byte("value of "+k)))
+	}
+
+	_, err := trie.DeletePrefix([]byte("block"))
+	require.NoError(t, err)
+	requireCounts(t, trie, trie.root)
+
+	_, err = trie.DeleteRange([]byte("accounts.b"), []byte("transfer.input.v"))
+	require.NoError(t, err)
+	requireCounts(t, trie, trie.root)
+
+	requireOrderStatistics(t, trie, map[string]bool{
+		"accounts":             true,
+		"accounts.address":     true,
+		"transfer.input.value": true,
+		"transfer.to":          true,
+	})
+}
+
+func TestRank_WithoutCounts(t *testing.T) {
+	trie := buildTrie(t, namespacedKeys)
+	stored := map[string]bool{}
+	for _, k := range namespacedKeys {
+		stored[k] = true
+	}
+
+	requireOrderStatistics(t, trie, stored)
+	require.Equal(t, 0, trie.Rank([]byte("a")))
+	require.Equal(t, 4, trie.Rank([]byte("b")))
+	require.Equal(t, len(namespacedKeys), trie.Rank([]byte("z")))
+}
+
+func TestCounts_ShouldOnlyChangeHashWhenCommitted(t *testing.T) {
+	plain := buildTrie(t, namespacedKeys)
+	counted := NewTrie(WithCounts())
+	committed := NewTrie(WithCommittedCounts())
+
+	for _, k := range namespacedKeys {
+		require.NoError(t, counted.Put([]byte(k), []byte("value of "+k)))
+		require.NoError(t, committed.Put([]byte(k), []byte("value of "+k)))
+	}
+
+	require.Equal(t, plain.Hash(), counted.Hash())
+	require.NotEqual(t, plain.Hash(), committed.Hash())
+
+	// the same keys must lead to the same committed root
+	before := committed.Hash()
+	require.NoError(t, committed.Delete([]byte("accounts")))
+	require.NotEqual(t, before, committed.Hash())
+
+	require.NoError(t, committed.Put([]byte("accounts"), []byte("value of accounts")))
+	require.Equal(t, before, committed.Hash())
+}
+
+func TestCounts_ShouldReopenCommittedCounts(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	trie := NewTrie(WithCommittedCounts())
+	stored := make(map[string]bool)
+
+	for i := 0; i < 300; i++ {
+		key := make([]byte, 1+r.Intn(4))
+		r.Read(key)
+		require.NoError(t, trie.Put(key, []byte(fmt.Sprintf("value %d", i))))
+		stored[string(key)] = true
+	}
+
+	db := NewInMemoryStorage()
+	root, err := trie.Commit(db)
+	require.NoError(t, err)
+	require.Equal(t, trie.Hash(), root)
+
+	opened := OpenTrie(root, db, WithCommittedCounts())
+	require.Equal(t, root, opened.Hash())
+	requireOrderStatistics(t, opened, stored)
+
+	// changes keep the counts of the nodes still in storage
+	for i := 0; i < 50; i++ {
+		key := make([]byte, 1+r.Intn(4))
+		r.Read(key)
+
+		if i%2 == 0 {
+			require.NoError(t, trie.Put(key, []byte("new")))
+			require.NoError(t, opened.Put(key, []byte("new")))
+			stored[string(key)] = true
+			continue
+		}
+
+		for k := range stored {
+			require.NoError(t, trie.Delete([]byte(k)))
+			require.NoError(t, opened.Delete([]byte(k)))
+			delete(stored, k)
+			break
+		}
+	}
+
+	require.Equal(t, trie.Hash(), opened.Hash())
+	requireOrderStatistics(t, opened, stored)
+}
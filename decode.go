@@ -0,0 +1,202 @@
+package mptrie
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+var (
+	ErrInvalidNode = errors.New("invalid encoded node")
+)
+
+// DecodeNode rebuilds a node from its serialized form, children referenced by
+// hash are returned as HashNode while embedded children are decoded in place.
+func DecodeNode(b []byte) (Node, error) {
+	elems, _, err := rlp.SplitList(b)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidNode, err)
+	}
+
+	count, err := rlp.CountValues(elems)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidNode, err)
+	}
+
+	switch count {
+	case 2:
+		return decodeShort(elems)
+	case 17:
+		return decodeBranch(elems)
+	case 3, 18:
+		return decodeCounted(elems, count)
+	}
+
+	return nil, fmt.Errorf("%w: list with %d items", ErrInvalidNode, count)
+}
+
+// decodeShort decodes either a leaf or an extension node, they are told apart
+// by the prefix added with ToPrefixed
+func decodeShort(elems []byte) (Node, error) {
+	key, rest, err := rlp.SplitString(elems)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidNode, err)
+	}
+
+	if len(key) == 0 {
+		return nil, fmt.Errorf("%w: empty path", ErrInvalidNode)
+	}
+
+	nibbles := FromBytes(key)
+	flag := nibbles[0]
+	if flag > 3 {
+		return nil, fmt.Errorf("%w: unknown path prefix %d", ErrInvalidNode, flag)
+	}
+
+	path := nibbles[2:]
+	if flag%2 == 1 {
+		path = nibbles[1:]
+	}
+
+	if flag >= 2 {
+		value, hash, err := decodeValue(rest)
+		if err != nil {
+			return nil, err
+		}
+
+		leaf := NewLeafNodeFromNibbles(path, value)
+		leaf.ValueHash = hash
+		return leaf, nil
+	}
+
+	next, _, err := decodeReference(rest)
+	if err != nil {
+		return nil, err
+	}
+
+	if next == nil {
+		return nil, fmt.Errorf("%w: extension without next node", ErrInvalidNode)
+	}
+
+	return NewExtensionNode(path, next), nil
+}
+
+// decodeCounted decodes the nodes committed WithCommittedCounts, an
+// extension or a branch followed by the number of keys below it
+func decodeCounted(elems []byte, items int) (Node, error) {
+	rest := elems
+	for i := 0; i < items-1; i++ {
+		_, _, next, err := rlp.Split(rest)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidNode, err)
+		}
+
+		rest = next
+	}
+
+	var count uint64
+	if err := rlp.DecodeBytes(rest, &count); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidNode, err)
+	}
+
+	body := elems[:len(elems)-len(rest)]
+	if items == 18 {
+		n, err := decodeBranch(body)
+		if err != nil {
+			return nil, err
+		}
+
+		n.(*BranchNode).Count = int(count)
+		return n, nil
+	}
+
+	n, err := decodeShort(body)
+	if err != nil {
+		return nil, err
+	}
+
+	ext, ok := n.(*ExtensionNode)
+	if !ok {
+		return nil, fmt.Errorf("%w: leaf with a count", ErrInvalidNode)
+	}
+
+	ext.Count = int(count)
+	return ext, nil
+}
+
+func decodeBranch(elems []byte) (Node, error) {
+	branch := NewBranchNode()
+
+	for i := 0; i < 16; i++ {
+		child, rest, err := decodeReference(elems)
+		if err != nil {
+			return nil, err
+		}
+
+		branch.SetBranch(Nibble(i), child)
+		elems = rest
+	}
+
+	value, hash, err := decodeValue(elems)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(value) > 0 {
+		branch.SetValue(value)
+	}
+
+	branch.ValueHash = hash
+	return branch, nil
+}
+
+// decodeValue decodes either a value or, when it is a list, the hash of a
+// value stored apart
+func decodeValue(b []byte) (value, hash []byte, err error) {
+	kind, content, _, err := rlp.Split(b)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidNode, err)
+	}
+
+	if kind != rlp.List {
+		return copyBytes(content), nil, nil
+	}
+
+	hash, _, err = rlp.SplitString(content)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidNode, err)
+	}
+
+	if len(hash) == 0 {
+		return nil, nil, fmt.Errorf("%w: empty value hash", ErrInvalidNode)
+	}
+
+	return nil, copyBytes(hash), nil
+}
+
+// decodeReference decodes the way a parent points to a child, see Reference
+func decodeReference(b []byte) (Node, []byte, error) {
+	kind, content, rest, err := rlp.Split(b)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidNode, err)
+	}
+
+	switch {
+	case kind == rlp.List:
+		embedded, err := DecodeNode(b[:len(b)-len(rest)])
+		return embedded, rest, err
+	case kind == rlp.String && len(content) == 0:
+		return nil, rest, nil
+	case kind == rlp.String && len(content) == 32:
+		return HashNode(copyBytes(content)), rest, nil
+	}
+
+	return nil, nil, fmt.Errorf("%w: child reference with %d bytes", ErrInvalidNode, len(content))
+}
+
+func copyBytes(b []byte) []byte {
+	c := make([]byte, len(b))
+	copy(c, b)
+	return c
+}
@@ -0,0 +1,64 @@
+package mptrie
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeNode_ShouldRoundTripEveryNode(t *testing.T) {
+	trie := buildTrie(t, namespacedKeys)
+
+	var visit func(n Node)
+	visit = func(n Node) {
+		if n == nil {
+			return
+		}
+
+		decoded, err := DecodeNode(Serialize(n))
+		require.NoError(t, err)
+		require.Equal(t, Serialize(n), Serialize(decoded))
+		require.Equal(t, Hash(n), Hash(decoded))
+
+		switch node := n.(type) {
+		case *BranchNode:
+			for _, child := range node.Branches {
+				visit(child)
+			}
+		case *ExtensionNode:
+			visit(node.Next)
+		}
+	}
+
+	visit(trie.root)
+}
+
+func TestDecodeNode_ShouldKeepLargeChildrenAsHashNodes(t *testing.T) {
+	trie := NewTrie()
+
+	err := trie.Put([]byte("first"), []byte("a value that is long enough to be hashed"))
+	require.NoError(t, err)
+
+	err = trie.Put([]byte("second"), []byte("x"))
+	require.NoError(t, err)
+
+	decoded, err := DecodeNode(Serialize(trie.root))
+	require.NoError(t, err)
+	require.IsType(t, &BranchNode{}, decoded)
+
+	branch := decoded.(*BranchNode)
+	first, second := FromBytes([]byte("first")), FromBytes([]byte("second"))
+
+	require.IsType(t, HashNode{}, branch.Branches[first[0]])
+	require.IsType(t, &LeafNode{}, branch.Branches[second[0]])
+	require.Equal(t, trie.root.(*BranchNode).Branches[first[0]].Hash(), branch.Branches[first[0]].Hash())
+}
+
+func TestDecodeNode_ShouldFailOnInvalidInput(t *testing.T) {
+	_, err := DecodeNode([]byte{0x01, 0x02})
+	require.ErrorIs(t, err, ErrInvalidNode)
+
+	// a list with 3 empty strings
+	_, err = DecodeNode([]byte{0xc3, 0x80, 0x80, 0x80})
+	require.ErrorIs(t, err, ErrInvalidNode)
+}
@@ -0,0 +1,397 @@
+package mptrie
+
+import (
+	"bytes"
+	"errors"
+)
+
+var (
+	ErrInvalidRange = errors.New("range start must not be greater than its end")
+)
+
+// Delete removes key from the trie, deleting a key that does not exist is not
+// an error. After the removal the nodes along the path are rewritten so the
+// trie keeps the same shape (and hash) it would have if key was never added.
+func (t *Trie) Delete(key []byte) error {
+	nibbles := FromBytes(key)
+
+	if len(nibbles) <= 0 {
+		return errors.New("cannot delete empty keys")
+	}
+
+	root, _, err := t.deleteKey(t.root, nibbles)
+	if err != nil {
+		return err
+	}
+
+	t.root = root
+
+	if t.counted {
+		return t.refreshCounts(nibbles)
+	}
+
+	return nil
+}
+
+// DeletePrefix cuts every key starting with prefix off the trie in one step and
+// returns how many keys were removed. An empty prefix clears the whole trie.
+func (t *Trie) DeletePrefix(prefix []byte) (int, error) {
+	nibbles := FromBytes(prefix)
+
+	root, removed, err := t.deletePrefix(t.root, nibbles)
+	if err != nil {
+		return 0, err
+	}
+
+	t.root = root
+
+	if t.counted {
+		if err := t.refreshCounts(nibbles); err != nil {
+			return 0, err
+		}
+	}
+
+	return removed, nil
+}
+
+// DeleteRange removes every key k where start <= k < end and returns how many
+// keys were removed, a nil end means the range has no upper bound. Subtrees
+// that lie entirely inside the range are dropped without being visited.
+func (t *Trie) DeleteRange(start, end []byte) (int, error) {
+	if end != nil && bytes.Compare(start, end) > 0 {
+		return 0, ErrInvalidRange
+	}
+
+	r := nibbleRange{start: FromBytes(start)}
+	if end != nil {
+		r.end = FromBytes(end)
+		r.bounded = true
+	}
+
+	root, removed, err := t.deleteRange(t.root, nil, r)
+	if err != nil {
+		return 0, err
+	}
+
+	t.root = root
+
+	// only the nodes along both ends of the range can be partially changed
+	if t.counted {
+		if err := t.refreshCounts(r.start); err != nil {
+			return 0, err
+		}
+
+		if err := t.refreshCounts(r.end); err != nil {
+			return 0, err
+		}
+	}
+
+	return removed, nil
+}
+
+func (t *Trie) deleteKey(n Node, nibbles []Nibble) (Node, bool, error) {
+	n, err := t.resolve(n)
+	if err != nil {
+		return nil, false, err
+	}
+
+	switch node := n.(type) {
+	case *LeafNode:
+		if CompareNibbles(node.Path, nibbles) != 0 {
+			return n, false, nil
+		}
+
+		return nil, true, nil
+	case *BranchNode:
+		if len(nibbles) == 0 {
+			if !node.HasValue() {
+				return n, false, nil
+			}
+
+			node = node.clone()
+			node.SetValue(nil)
+			n, err = t.canonical(node)
+			return n, true, err
+		}
+
+		child, removed, err := t.deleteKey(node.Branches[nibbles[0]], nibbles[1:])
+		if err != nil || !removed {
+			return n, false, err
+		}
+
+		node = node.clone()
+		node.SetBranch(nibbles[0], child)
+		n, err = t.canonical(node)
+		return n, true, err
+	case *ExtensionNode:
+		if !HasNibblePrefix(nibbles, node.Path) {
+			return n, false, nil
+		}
+
+		next, removed, err := t.deleteKey(node.Next, nibbles[len(node.Path):])
+		if err != nil || !removed {
+			return n, false, err
+		}
+
+		node = node.clone()
+		node.Next = next
+		n, err = t.canonical(node)
+		return n, true, err
+	}
+
+	return n, false, nil
+}
+
+func (t *Trie) deletePrefix(n Node, prefix []Nibble) (Node, int, error) {
+	if len(prefix) == 0 {
+		removed, err := t.countKeys(n)
+		return nil, removed, err
+	}
+
+	n, err := t.resolve(n)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch node := n.(type) {
+	case *LeafNode:
+		if !HasNibblePrefix(node.Path, prefix) {
+			return n, 0, nil
+		}
+
+		return nil, 1, nil
+	case *BranchNode:
+		child, removed, err := t.deletePrefix(node.Branches[prefix[0]], prefix[1:])
+		if err != nil || removed == 0 {
+			return n, 0, err
+		}
+
+		node = node.clone()
+		node.SetBranch(prefix[0], child)
+		n, err = t.canonical(node)
+		return n, removed, err
+	case *ExtensionNode:
+		matched := PrefixMatchedLen(node.Path, prefix)
+
+		// the prefix ends inside the extension path, everything below matches
+		if matched == len(prefix) {
+			removed, err := t.countKeys(node)
+			return nil, removed, err
+		}
+
+		if matched < len(node.Path) {
+			return n, 0, nil
+		}
+
+		next, removed, err := t.deletePrefix(node.Next, prefix[matched:])
+		if err != nil || removed == 0 {
+			return n, 0, err
+		}
+
+		node = node.clone()
+		node.Next = next
+		n, err = t.canonical(node)
+		return n, removed, err
+	}
+
+	return n, 0, nil
+}
+
+// nibbleRange is the half open interval [start, end) expressed in nibbles
+type nibbleRange struct {
+	start   []Nibble
+	end     []Nibble
+	bounded bool
+}
+
+func (r nibbleRange) contains(key []Nibble) bool {
+	if CompareNibbles(key, r.start) < 0 {
+		return false
+	}
+
+	return !r.bounded || CompareNibbles(key, r.end) < 0
+}
+
+// covers reports whether every key starting with prefix is inside the range
+func (r nibbleRange) covers(prefix []Nibble) bool {
+	if CompareNibbles(prefix, r.start) < 0 {
+		return false
+	}
+
+	if !r.bounded {
+		return true
+	}
+
+	return CompareNibbles(prefix, r.end) < 0 && !HasNibblePrefix(r.end, prefix)
+}
+
+// excludes reports whether no key starting with prefix is inside the range
+func (r nibbleRange) excludes(prefix []Nibble) bool {
+	if CompareNibbles(prefix, r.start) < 0 && !HasNibblePrefix(r.start, prefix) {
+		return true
+	}
+
+	return r.bounded && CompareNibbles(prefix, r.end) >= 0
+}
+
+func (t *Trie) deleteRange(n Node, path []Nibble, r nibbleRange) (Node, int, error) {
+	if n == nil || r.excludes(path) {
+		return n, 0, nil
+	}
+
+	if r.covers(path) {
+		removed, err := t.countKeys(n)
+		return nil, removed, err
+	}
+
+	n, err := t.resolve(n)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch node := n.(type) {
+	case *LeafNode:
+		if !r.contains(ConcatNibbles(path, node.Path)) {
+			return n, 0, nil
+		}
+
+		return nil, 1, nil
+	case *BranchNode:
+		node = node.clone()
+
+		removed := 0
+		if node.HasValue() && r.contains(path) {
+			node.SetValue(nil)
+			removed++
+		}
+
+		for i, branch := range node.Branches {
+			if branch == nil {
+				continue
+			}
+
+			child, count, err := t.deleteRange(branch, ConcatNibbles(path, []Nibble{Nibble(i)}), r)
+			if err != nil {
+				return nil, 0, err
+			}
+
+			node.SetBranch(Nibble(i), child)
+			removed += count
+		}
+
+		if removed == 0 {
+			return n, 0, nil
+		}
+
+		n, err = t.canonical(node)
+		return n, removed, err
+	case *ExtensionNode:
+		next, removed, err := t.deleteRange(node.Next, ConcatNibbles(path, node.Path), r)
+		if err != nil || removed == 0 {
+			return n, 0, err
+		}
+
+		node = node.clone()
+		node.Next = next
+		n, err = t.canonical(node)
+		return n, removed, err
+	}
+
+	return n, 0, nil
+}
+
+// canonical rewrites a node whose children may have been removed into the
+// shape Put would have built for the keys left below it:
+// BranchNode    -> removed when empty, a leaf when only its value is left or
+// merged with its child when only one branch is left
+// ExtensionNode -> removed when the next node is gone, merged with the next
+// node when it turned into a leaf or another extension
+func (t *Trie) canonical(n Node) (Node, error) {
+	switch node := n.(type) {
+	case *BranchNode:
+		children, last := 0, 0
+		for i, branch := range node.Branches {
+			if branch != nil {
+				children++
+				last = i
+			}
+		}
+
+		if children == 0 {
+			if !node.HasValue() {
+				return nil, nil
+			}
+
+			return NewLeafNodeFromNibbles([]Nibble{}, node.Value), nil
+		}
+
+		if children == 1 && !node.HasValue() {
+			return t.canonical(NewExtensionNode([]Nibble{Nibble(last)}, node.Branches[last]))
+		}
+
+		return node, nil
+	case *ExtensionNode:
+		// the next node must be loaded to know whether it can be merged
+		next, err := t.resolve(node.Next)
+		if err != nil {
+			return nil, err
+		}
+
+		switch next := next.(type) {
+		case nil:
+			return nil, nil
+		case *LeafNode:
+			return NewLeafNodeFromNibbles(ConcatNibbles(node.Path, next.Path), next.Value), nil
+		case *ExtensionNode:
+			return NewExtensionNode(ConcatNibbles(node.Path, next.Path), next.Next), nil
+		}
+
+		if len(node.Path) == 0 {
+			return next, nil
+		}
+
+		// keep the loaded node, codecs that merge an extension with its
+		// branch need it in memory to encode the extension
+		if node.Next != next {
+			node = node.clone()
+			node.Next = next
+		}
+
+		return node, nil
+	}
+
+	return n, nil
+}
+
+// countKeys returns how many values are stored below n
+func (t Trie) countKeys(n Node) (int, error) {
+	n, err := t.resolve(n)
+	if err != nil {
+		return 0, err
+	}
+
+	switch node := n.(type) {
+	case *LeafNode:
+		return 1, nil
+	case *BranchNode:
+		count := 0
+		if node.HasValue() {
+			count++
+		}
+
+		for _, branch := range node.Branches {
+			c, err := t.countKeys(branch)
+			if err != nil {
+				return 0, err
+			}
+
+			count += c
+		}
+
+		return count, nil
+	case *ExtensionNode:
+		return t.countKeys(node.Next)
+	}
+
+	return 0, nil
+}
@@ -0,0 +1,168 @@
+package mptrie
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var namespacedKeys = []string{
+	"accounts",
+	"accounts.address",
+	"accounts.balance",
+	"accounts.nonce",
+	"block.header",
+	"block.number",
+	"transfer.gas",
+	"transfer.input",
+	"transfer.input.value",
+	"transfer.to",
+}
+
+func buildTrie(t *testing.T, keys []string) *Trie {
+	trie := NewTrie()
+
+	for _, k := range keys {
+		err := trie.Put([]byte(k), []byte("value of "+k))
+		require.NoError(t, err)
+	}
+
+	return trie
+}
+
+func TestDelete_ShouldKeepSameHashAsTrieWithoutKey(t *testing.T) {
+	for i, k := range namespacedKeys {
+		trie := buildTrie(t, namespacedKeys)
+
+		err := trie.Delete([]byte(k))
+		require.NoError(t, err)
+
+		_, ok := trie.Get([]byte(k))
+		require.False(t, ok)
+
+		remaining := append(append([]string{}, namespacedKeys[:i]...), namespacedKeys[i+1:]...)
+		expected := buildTrie(t, remaining)
+		require.Equal(t, expected.Hash(), trie.Hash(), "deleting %s", k)
+	}
+}
+
+func TestDelete_ShouldEmptyTheTrie(t *testing.T) {
+	trie := buildTrie(t, namespacedKeys)
+
+	for _, k := range namespacedKeys {
+		err := trie.Delete([]byte(k))
+		require.NoError(t, err)
+	}
+
+	require.Nil(t, trie.root)
+	require.Equal(t, EmptyNodeHash, trie.Hash())
+}
+
+func TestDelete_ShouldIgnoreMissingKey(t *testing.T) {
+	trie := buildTrie(t, namespacedKeys)
+	before := trie.Hash()
+
+	err := trie.Delete([]byte("accounts.code"))
+	require.NoError(t, err)
+
+	err = trie.Delete([]byte("transfer"))
+	require.NoError(t, err)
+
+	require.Equal(t, before, trie.Hash())
+}
+
+func TestDelete_ShouldReturnErrWhenKeyEmpty(t *testing.T) {
+	trie := NewTrie()
+	err := trie.Delete([]byte(""))
+
+	require.Error(t, err)
+}
+
+func TestDeletePrefix_ShouldRemoveNamespace(t *testing.T) {
+	trie := buildTrie(t, namespacedKeys)
+
+	removed, err := trie.DeletePrefix([]byte("transfer."))
+	require.NoError(t, err)
+	require.Equal(t, 4, removed)
+
+	expected := buildTrie(t, namespacedKeys[:6])
+	require.Equal(t, expected.Hash(), trie.Hash())
+
+	removed, err = trie.DeletePrefix([]byte("accounts"))
+	require.NoError(t, err)
+	require.Equal(t, 4, removed)
+
+	expected = buildTrie(t, namespacedKeys[4:6])
+	require.Equal(t, expected.Hash(), trie.Hash())
+
+	v, ok := trie.Get([]byte("block.number"))
+	require.True(t, ok)
+	require.Equal(t, []byte("value of block.number"), v)
+}
+
+func TestDeletePrefix_WhenPrefixEndsInsideExtension(t *testing.T) {
+	trie := buildTrie(t, namespacedKeys)
+
+	// "bl" ends inside the extension shared by the block.* keys
+	removed, err := trie.DeletePrefix([]byte("bl"))
+	require.NoError(t, err)
+	require.Equal(t, 2, removed)
+
+	remaining := append(append([]string{}, namespacedKeys[:4]...), namespacedKeys[6:]...)
+	expected := buildTrie(t, remaining)
+	require.Equal(t, expected.Hash(), trie.Hash())
+}
+
+func TestDeletePrefix_ShouldReturnZeroWhenNothingMatches(t *testing.T) {
+	trie := buildTrie(t, namespacedKeys)
+	before := trie.Hash()
+
+	removed, err := trie.DeletePrefix([]byte("validators."))
+	require.NoError(t, err)
+	require.Zero(t, removed)
+	require.Equal(t, before, trie.Hash())
+}
+
+func TestDeletePrefix_WithEmptyPrefixShouldClearTrie(t *testing.T) {
+	trie := buildTrie(t, namespacedKeys)
+
+	removed, err := trie.DeletePrefix(nil)
+	require.NoError(t, err)
+	require.Equal(t, len(namespacedKeys), removed)
+	require.Equal(t, EmptyNodeHash, trie.Hash())
+}
+
+func TestDeleteRange_ShouldRemoveKeysInsideRange(t *testing.T) {
+	trie := buildTrie(t, namespacedKeys)
+
+	removed, err := trie.DeleteRange([]byte("accounts.b"), []byte("transfer.input"))
+	require.NoError(t, err)
+	require.Equal(t, 5, removed)
+
+	remaining := []string{"accounts", "accounts.address", "transfer.input", "transfer.input.value", "transfer.to"}
+	expected := buildTrie(t, remaining)
+	require.Equal(t, expected.Hash(), trie.Hash())
+
+	for _, k := range remaining {
+		_, ok := trie.Get([]byte(k))
+		require.True(t, ok, k)
+	}
+}
+
+func TestDeleteRange_WithoutUpperBound(t *testing.T) {
+	trie := buildTrie(t, namespacedKeys)
+
+	removed, err := trie.DeleteRange([]byte("block.number"), nil)
+	require.NoError(t, err)
+	require.Equal(t, 5, removed)
+
+	expected := buildTrie(t, namespacedKeys[:5])
+	require.Equal(t, expected.Hash(), trie.Hash())
+}
+
+func TestDeleteRange_ShouldReturnErrWhenStartGreaterThanEnd(t *testing.T) {
+	trie := buildTrie(t, namespacedKeys)
+
+	_, err := trie.DeleteRange([]byte("z"), []byte("a"))
+	require.ErrorIs(t, err, ErrInvalidRange)
+}
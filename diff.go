@@ -0,0 +1,164 @@
+package mptrie
+
+import "bytes"
+
+type ChangeKind int
+
+const (
+	Added ChangeKind = iota
+	Removed
+	Modified
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case Added:
+		return "added"
+	case Removed:
+		return "removed"
+	case Modified:
+		return "modified"
+	}
+
+	return "unknown"
+}
+
+// Change describes how the value of a key differs between two tries, From is
+// nil for added keys and To is nil for removed keys.
+type Change struct {
+	Kind ChangeKind
+	Key  []byte
+	From []byte
+	To   []byte
+}
+
+// Diff walks a and b in step and calls onChange for every key whose value is
+// not the same in both tries, in ascending key order. Subtrees with the same
+// hash on both sides are skipped, so on tries opened from storage only the
+// nodes that differ are loaded. Returning an error from onChange stops the
+// walk and the error is returned by Diff.
+func Diff(a, b *Trie, onChange func(Change) error) error {
+	d := differ{a: a, b: b, onChange: onChange}
+	return d.diff(nil, cursor{node: a.root}, cursor{node: b.root})
+}
+
+// cursor points to the subtree found after following a path from the root,
+// it can stop in the middle of the path of a leaf or an extension node, in
+// that case offset is how many nibbles of that path were already consumed.
+type cursor struct {
+	node   Node
+	offset int
+}
+
+// atNode reports whether the cursor points to the start of a node, the only
+// place where the subtree hash is known
+func (c cursor) atNode() bool {
+	return c.node != nil && c.offset == 0
+}
+
+// expanded is the view of a subtree as if it was a branch node, holding the
+// value stored exactly at its path and the subtrees for each nibble after it
+type expanded struct {
+	value    []byte
+	children [16]cursor
+}
+
+type differ struct {
+	a, b     *Trie
+	onChange func(Change) error
+}
+
+func (d differ) diff(path []Nibble, a, b cursor) error {
+	if a.node == nil && b.node == nil {
+		return nil
+	}
+
+	if a.atNode() && b.atNode() && bytes.Equal(d.a.encoder().hash(a.node), d.b.encoder().hash(b.node)) {
+		return nil
+	}
+
+	ea, err := expand(d.a, a)
+	if err != nil {
+		return err
+	}
+
+	eb, err := expand(d.b, b)
+	if err != nil {
+		return err
+	}
+
+	if err := d.compare(path, ea.value, eb.value); err != nil {
+		return err
+	}
+
+	for i := 0; i < 16; i++ {
+		if err := d.diff(ConcatNibbles(path, []Nibble{Nibble(i)}), ea.children[i], eb.children[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d differ) compare(path []Nibble, from, to []byte) error {
+	var change Change
+
+	switch {
+	case from == nil && to == nil:
+		return nil
+	case from == nil:
+		change = Change{Kind: Added, To: to}
+	case to == nil:
+		change = Change{Kind: Removed, From: from}
+	case !bytes.Equal(from, to):
+		change = Change{Kind: Modified, From: from, To: to}
+	default:
+		return nil
+	}
+
+	change.Key = ToBytes(path)
+	return d.onChange(change)
+}
+
+func expand(t *Trie, c cursor) (expanded, error) {
+	var e expanded
+
+	if c.node == nil {
+		return e, nil
+	}
+
+	n, err := t.resolve(c.node)
+	if err != nil {
+		return e, err
+	}
+
+	switch node := n.(type) {
+	case *LeafNode:
+		if c.offset == len(node.Path) {
+			e.value = node.Value
+		} else {
+			e.children[node.Path[c.offset]] = cursor{node: node, offset: c.offset + 1}
+		}
+	case *BranchNode:
+		if node.HasValue() {
+			e.value = node.Value
+		}
+
+		for i, child := range node.Branches {
+			e.children[i] = cursor{node: child}
+		}
+	case *ExtensionNode:
+		if c.offset == len(node.Path) {
+			return expand(t, cursor{node: node.Next})
+		}
+
+		next := cursor{node: node, offset: c.offset + 1}
+		if next.offset == len(node.Path) {
+			next = cursor{node: node.Next}
+		}
+
+		e.children[node.Path[c.offset]] = next
+	}
+
+	return e, nil
+}
@@ -0,0 +1,141 @@
+package mptrie
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// countingReader counts how many nodes were loaded from the storage
+type countingReader struct {
+	NodeReader
+	loads int
+}
+
+func (c *countingReader) Get(key []byte) ([]byte, error) {
+	c.loads++
+	return c.NodeReader.Get(key)
+}
+
+func collectDiff(t *testing.T, a, b *Trie) []Change {
+	var changes []Change
+
+	err := Diff(a, b, func(c Change) error {
+		changes = append(changes, c)
+		return nil
+	})
+	require.NoError(t, err)
+
+	return changes
+}
+
+func TestDiff_ShouldReportAddedRemovedAndModified(t *testing.T) {
+	a := buildTrie(t, namespacedKeys)
+	b := buildTrie(t, namespacedKeys)
+
+	require.NoError(t, b.Delete([]byte("accounts.nonce")))
+	require.NoError(t, b.Delete([]byte("transfer.input")))
+	require.NoError(t, b.Put([]byte("accounts.code"), []byte("code")))
+	require.NoError(t, b.Put([]byte("block.number"), []byte("2")))
+	require.NoError(t, b.Put([]byte("transfer"), []byte("pending")))
+
+	expected := []Change{
+		{Kind: Added, Key: []byte("accounts.code"), To: []byte("code")},
+		{Kind: Removed, Key: []byte("accounts.nonce"), From: []byte("value of accounts.nonce")},
+		{Kind: Modified, Key: []byte("block.number"), From: []byte("value of block.number"), To: []byte("2")},
+		{Kind: Added, Key: []byte("transfer"), To: []byte("pending")},
+		{Kind: Removed, Key: []byte("transfer.input"), From: []byte("value of transfer.input")},
+	}
+
+	require.Equal(t, expected, collectDiff(t, a, b))
+}
+
+func TestDiff_WithEmptyTries(t *testing.T) {
+	empty := NewTrie()
+	full := buildTrie(t, namespacedKeys)
+
+	require.Empty(t, collectDiff(t, empty, NewTrie()))
+	require.Empty(t, collectDiff(t, full, buildTrie(t, namespacedKeys)))
+
+	added := collectDiff(t, empty, full)
+	require.Len(t, added, len(namespacedKeys))
+	for i, c := range added {
+		require.Equal(t, Added, c.Kind)
+		require.Equal(t, sortedKeys(namespacedKeys)[i], c.Key)
+	}
+
+	removed := collectDiff(t, full, empty)
+	require.Len(t, removed, len(namespacedKeys))
+	for _, c := range removed {
+		require.Equal(t, Removed, c.Kind)
+	}
+}
+
+func TestDiff_ShouldStopOnCallbackError(t *testing.T) {
+	expected := fmt.Errorf("stop")
+
+	err := Diff(NewTrie(), buildTrie(t, namespacedKeys), func(c Change) error {
+		return expected
+	})
+	require.ErrorIs(t, err, expected)
+}
+
+func TestDiff_ShouldOnlyLoadDifferingNodes(t *testing.T) {
+	a := NewTrie()
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("account-%04d", i))
+		require.NoError(t, a.Put(key, []byte(fmt.Sprintf("balance %d", i))))
+	}
+
+	db := NewInMemoryStorage()
+	rootA, err := a.Commit(db)
+	require.NoError(t, err)
+
+	require.NoError(t, a.Put([]byte("account-0500"), []byte("balance 0")))
+	require.NoError(t, a.Delete([]byte("account-0999")))
+
+	rootB, err := a.Commit(db)
+	require.NoError(t, err)
+
+	readerA, readerB := &countingReader{NodeReader: db}, &countingReader{NodeReader: db}
+	changes := collectDiff(t, OpenTrie(rootA, readerA), OpenTrie(rootB, readerB))
+
+	expected := []Change{
+		{Kind: Modified, Key: []byte("account-0500"), From: []byte("balance 500"), To: []byte("balance 0")},
+		{Kind: Removed, Key: []byte("account-0999"), From: []byte("balance 999")},
+	}
+	require.Equal(t, expected, changes)
+
+	// only the two paths down to the changed keys are loaded
+	require.Less(t, readerA.loads, 20)
+	require.Less(t, readerB.loads, 20)
+}
+
+func TestOpenTrie_ShouldLoadCommittedNodes(t *testing.T) {
+	trie := buildTrie(t, namespacedKeys)
+
+	db := NewInMemoryStorage()
+	root, err := trie.Commit(db)
+	require.NoError(t, err)
+	require.Equal(t, trie.Hash(), root)
+
+	opened := OpenTrie(root, db)
+	require.Equal(t, root, opened.Hash())
+
+	for _, k := range namespacedKeys {
+		v, ok, err := opened.TryGet([]byte(k))
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, []byte("value of "+k), v)
+	}
+
+	require.NoError(t, opened.Put([]byte("accounts.code"), []byte("code")))
+	require.NoError(t, opened.Delete([]byte("block.header")))
+	require.NoError(t, trie.Put([]byte("accounts.code"), []byte("code")))
+	require.NoError(t, trie.Delete([]byte("block.header")))
+	require.Equal(t, trie.Hash(), opened.Hash())
+
+	_, _, err = OpenTrie(root, NewInMemoryStorage()).TryGet([]byte("accounts"))
+	require.ErrorIs(t, err, KeyNotFound)
+}
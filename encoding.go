@@ -0,0 +1,75 @@
+package mptrie
+
+// encoder turns nodes into their serialized form and hashes following the
+// settings of a trie, nodes built without a trie use defaultEncoder.
+type encoder struct {
+	hasher Hasher
+	codec  NodeCodec
+
+	// inline is the size from which nodes are referenced by their hash
+	inline int
+	// hashedValues is the size from which values are referenced by their
+	// hash, values are never hashed when it is 0
+	hashedValues int
+}
+
+var defaultEncoder = encoder{hasher: Keccak256, codec: RLPCodec, inline: 32}
+
+// raw returns the RLP list for n, kept for the Raw method of the nodes
+func (e encoder) raw(n Node) []interface{} {
+	return rlpCodec{}.raw(n, e.reference, e.valueReference)
+}
+
+func (e encoder) serialize(n Node) []byte {
+	return e.codec.Encode(n, e.reference, e.valueReference)
+}
+
+func (e encoder) hash(n Node) []byte {
+	if hash, ok := n.(HashNode); ok {
+		return hash
+	}
+
+	return e.hasher.Hash(e.serialize(n))
+}
+
+// reference returns how a parent node refers to n, nodes whose encoding has
+// at least inline bytes (32 by default) are referenced by their hash while
+// smaller ones are embedded.
+func (e encoder) reference(n Node) ([]byte, bool) {
+	if hash, ok := n.(HashNode); ok {
+		return hash, true
+	}
+
+	encoded := e.serialize(n)
+	if e.hashed(encoded) {
+		return e.hasher.Hash(encoded), true
+	}
+
+	return encoded, false
+}
+
+func (e encoder) hashed(encoded []byte) bool {
+	return len(encoded) >= e.inline
+}
+
+// valueReference returns how a node refers to value, see WithHashedValues
+func (e encoder) valueReference(value []byte) ([]byte, bool) {
+	if e.hashedValue(value) {
+		return e.hasher.Hash(value), true
+	}
+
+	return value, false
+}
+
+func (e encoder) hashedValue(value []byte) bool {
+	return e.hashedValues > 0 && len(value) >= e.hashedValues
+}
+
+func (e encoder) decode(b []byte) (Node, error) {
+	return e.codec.Decode(b)
+}
+
+// emptyRoot is the hash of a trie without keys
+func (e encoder) emptyRoot() []byte {
+	return e.hasher.Hash(e.serialize(nil))
+}
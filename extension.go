@@ -1,10 +1,12 @@
 package mptrie
 
-import "github.com/ethereum/go-ethereum/crypto"
-
 type ExtensionNode struct {
 	Path []Nibble
 	Next Node
+
+	// Count is the number of keys below the node, only kept up to date
+	// when the trie is created WithCounts
+	Count int
 }
 
 func NewExtensionNode(nibbles []Nibble, next Node) *ExtensionNode {
@@ -14,22 +16,20 @@ func NewExtensionNode(nibbles []Nibble, next Node) *ExtensionNode {
 	}
 }
 
+// clone returns a copy of e that shares its next node
+func (e *ExtensionNode) clone() *ExtensionNode {
+	c := *e
+	return &c
+}
+
 func (e ExtensionNode) Hash() []byte {
-	return crypto.Keccak256(e.Serialize())
+	return defaultEncoder.hash(&e)
 }
 
 func (e ExtensionNode) Serialize() []byte {
-	return Serialize(e)
+	return defaultEncoder.serialize(&e)
 }
 
 func (e ExtensionNode) Raw() []interface{} {
-	hashes := make([]interface{}, 2)
-	hashes[0] = ToBytes(ToPrefixed(e.Path, false))
-	if len(Serialize(e.Next)) >= 32 {
-		hashes[1] = e.Next.Hash()
-	} else {
-		hashes[1] = e.Next.Raw()
-	}
-
-	return hashes
+	return defaultEncoder.raw(&e)
 }
@@ -0,0 +1,190 @@
+package mptrie
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+
+	ethtrie "github.com/ethereum/go-ethereum/trie"
+)
+
+const (
+	fuzzPut byte = iota
+	fuzzGet
+	fuzzDelete
+	fuzzHash
+	fuzzProve
+	fuzzOps
+)
+
+// fuzzStream reads the operations of a fuzz input. Each operation starts
+// with its code, get, delete and prove are followed by a key and put by a
+// key and a value. A key is a length byte and that many key bytes, masked
+// with 0x33 so keys often share prefixes. A value is a length byte and a
+// fill byte, values run from 1 to 64 bytes so nodes are both embedded and
+// referenced by hash.
+type fuzzStream struct {
+	data []byte
+}
+
+func (s *fuzzStream) byte() (byte, bool) {
+	if len(s.data) == 0 {
+		return 0, false
+	}
+
+	b := s.data[0]
+	s.data = s.data[1:]
+	return b, true
+}
+
+func (s *fuzzStream) key() ([]byte, bool) {
+	n, ok := s.byte()
+	if !ok {
+		return nil, false
+	}
+
+	key := make([]byte, 1+int(n)%6)
+	for i := range key {
+		b, ok := s.byte()
+		if !ok {
+			return nil, false
+		}
+
+		key[i] = b & 0x33
+	}
+
+	return key, true
+}
+
+func (s *fuzzStream) value() ([]byte, bool) {
+	n, ok := s.byte()
+	if !ok {
+		return nil, false
+	}
+
+	fill, ok := s.byte()
+	if !ok {
+		return nil, false
+	}
+
+	return bytes.Repeat([]byte{fill}, 1+int(n)%64), true
+}
+
+// fuzzInput encodes ops for the seed corpus, each op is a code followed by
+// its key length, key bytes and, for puts, value length and fill byte
+func fuzzInput(ops ...[]byte) []byte {
+	var data []byte
+	for _, op := range ops {
+		data = append(data, op...)
+	}
+
+	return data
+}
+
+func putOp(key []byte, size, fill byte) []byte {
+	return append(append([]byte{fuzzPut, byte(len(key) - 1)}, key...), size-1, fill)
+}
+
+func keyOp(code byte, key []byte) []byte {
+	return append([]byte{code, byte(len(key) - 1)}, key...)
+}
+
+func FuzzTrie_DifferentialWithGeth(f *testing.F) {
+	hash := []byte{fuzzHash}
+
+	// a value on a branch: "\x01" ends where "\x01\x02" goes on
+	f.Add(fuzzInput(putOp([]byte{0x01}, 4, 'a'), putOp([]byte{0x01, 0x02}, 4, 'b'), hash,
+		keyOp(fuzzProve, []byte{0x01}), keyOp(fuzzGet, []byte{0x01}), keyOp(fuzzDelete, []byte{0x01}), hash))
+
+	// keys that are prefixes of each other, deleted from the longest
+	f.Add(fuzzInput(putOp([]byte{0x11}, 40, 'a'), putOp([]byte{0x11, 0x22}, 40, 'b'), putOp([]byte{0x11, 0x22, 0x33}, 40, 'c'),
+		hash, keyOp(fuzzProve, []byte{0x11, 0x22}), keyOp(fuzzDelete, []byte{0x11, 0x22, 0x33}), hash,
+		keyOp(fuzzDelete, []byte{0x11, 0x22}), hash, keyOp(fuzzGet, []byte{0x11}), keyOp(fuzzProve, []byte{0x11})))
+
+	// nodes under 32 bytes embedded in their parents
+	f.Add(fuzzInput(putOp([]byte{0x00}, 1, 'x'), putOp([]byte{0x01}, 1, 'y'), putOp([]byte{0x02}, 1, 'z'),
+		putOp([]byte{0x03, 0x00}, 2, 'w'), hash, keyOp(fuzzProve, []byte{0x03, 0x00}), keyOp(fuzzDelete, []byte{0x00}),
+		keyOp(fuzzDelete, []byte{0x01}), hash, keyOp(fuzzProve, []byte{0x02})))
+
+	// an extension split in the middle and merged back
+	f.Add(fuzzInput(putOp([]byte{0x12, 0x30, 0x01}, 33, 'a'), putOp([]byte{0x12, 0x30, 0x02}, 33, 'b'),
+		putOp([]byte{0x12, 0x01}, 5, 'c'), hash, keyOp(fuzzDelete, []byte{0x12, 0x01}), hash,
+		keyOp(fuzzGet, []byte{0x12, 0x30, 0x02}), keyOp(fuzzProve, []byte{0x12, 0x30, 0x01})))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		trie := NewTrie()
+		eth, err := ethtrie.New(common.Hash{}, ethtrie.NewDatabase(memorydb.New()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		stream := &fuzzStream{data: data}
+		for {
+			code, ok := stream.byte()
+			if !ok {
+				return
+			}
+
+			if code%fuzzOps == fuzzHash {
+				if !bytes.Equal(trie.Hash(), eth.Hash().Bytes()) {
+					t.Fatalf("root %x, geth has %x", trie.Hash(), eth.Hash())
+				}
+
+				continue
+			}
+
+			key, ok := stream.key()
+			if !ok {
+				return
+			}
+
+			switch code % fuzzOps {
+			case fuzzPut:
+				value, ok := stream.value()
+				if !ok {
+					return
+				}
+
+				if err := trie.Put(key, value); err != nil {
+					t.Fatal(err)
+				}
+
+				eth.Update(key, value)
+			case fuzzDelete:
+				if err := trie.Delete(key); err != nil {
+					t.Fatal(err)
+				}
+
+				eth.Delete(key)
+			case fuzzGet:
+				value, ok := trie.Get(key)
+				expected := eth.Get(key)
+
+				if ok != (expected != nil) || !bytes.Equal(value, expected) {
+					t.Fatalf("get %x: %x (%v), geth has %x", key, value, ok, expected)
+				}
+			case fuzzProve:
+				// proofs are only built for keys in the trie, geth must
+				// accept them with the value it holds
+				expected := eth.Get(key)
+
+				proof := NewInMemoryStorage()
+				err := CreateProof(key, trie, proof)
+				if (err == nil) != (expected != nil) {
+					t.Fatalf("proof of %x: %v, geth has %x", key, err, expected)
+				}
+
+				if err != nil {
+					continue
+				}
+
+				value, err := ethtrie.VerifyProof(eth.Hash(), key, proof)
+				if err != nil || !bytes.Equal(value, expected) {
+					t.Fatalf("proof of %x: geth verified %x, %v", key, value, err)
+				}
+			}
+		}
+	})
+}
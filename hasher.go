@@ -0,0 +1,48 @@
+package mptrie
+
+import (
+	"crypto/sha256"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"golang.org/x/crypto/blake2b"
+)
+
+// Hasher computes the hashes that reference nodes, it must return 32 bytes.
+type Hasher interface {
+	Hash(data []byte) []byte
+}
+
+var (
+	// Keccak256 is the default hasher, the one used by Ethereum
+	Keccak256 Hasher = keccak256{}
+	SHA256    Hasher = sha256Hasher{}
+	Blake2b   Hasher = blake2b256{}
+)
+
+type keccak256 struct{}
+
+func (keccak256) Hash(data []byte) []byte {
+	return crypto.Keccak256(data)
+}
+
+type sha256Hasher struct{}
+
+func (sha256Hasher) Hash(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+type blake2b256 struct{}
+
+func (blake2b256) Hash(data []byte) []byte {
+	sum := blake2b.Sum256(data)
+	return sum[:]
+}
+
+// WithHasher builds the trie hashing its nodes with h instead of Keccak256,
+// the root of an empty trie is derived from h as well.
+func WithHasher(h Hasher) Option {
+	return func(t *Trie) {
+		t.hasher = h
+	}
+}
@@ -0,0 +1,72 @@
+package mptrie
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/blake2b"
+
+	ethtrie "github.com/ethereum/go-ethereum/trie"
+)
+
+func TestHasher_DefaultShouldMatchEthereumRoot(t *testing.T) {
+	trie := buildTrie(t, namespacedKeys)
+
+	eth, err := ethtrie.New(common.Hash{}, ethtrie.NewDatabase(rawdb.NewMemoryDatabase()))
+	require.NoError(t, err)
+
+	for _, k := range namespacedKeys {
+		eth.Update([]byte(k), []byte("value of "+k))
+	}
+
+	require.Equal(t, eth.Hash().Bytes(), trie.Hash())
+	require.Equal(t, EmptyNodeHash, NewTrie().Hash())
+}
+
+func TestHasher_EmptyRootShouldBeDerivedFromHasher(t *testing.T) {
+	sha := sha256.Sum256([]byte{0x80})
+	require.Equal(t, sha[:], NewTrie(WithHasher(SHA256)).Hash())
+
+	blake := blake2b.Sum256([]byte{0x80})
+	require.Equal(t, blake[:], NewTrie(WithHasher(Blake2b)).Hash())
+}
+
+func TestHasher_ShouldChangeRootAndKeepWorking(t *testing.T) {
+	for _, h := range []Hasher{SHA256, Blake2b} {
+		trie := NewTrie(WithHasher(h))
+		for _, k := range routeKeys {
+			require.NoError(t, trie.Put([]byte(k), []byte("value of "+k)))
+		}
+
+		require.NotEqual(t, buildTrie(t, routeKeys).Hash(), trie.Hash())
+
+		db := NewInMemoryStorage()
+		root, err := trie.Commit(db)
+		require.NoError(t, err)
+		require.Equal(t, trie.Hash(), root)
+
+		_, err = db.Get(root)
+		require.NoError(t, err)
+
+		opened := OpenTrie(root, db, WithHasher(h))
+		v, ok, err := opened.TryGet([]byte("service/eu"))
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, []byte("value of service/eu"), v)
+
+		proof := NewInMemoryStorage()
+		require.NoError(t, CreateLongestPrefixProof([]byte("service/eu/host-02"), trie, proof))
+
+		key, _, ok, err := VerifyLongestPrefixProof(root, []byte("service/eu/host-02"), proof, WithHasher(h))
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, []byte("service/eu"), key)
+
+		// verifying with the default hasher must reject the proof
+		_, _, _, err = VerifyLongestPrefixProof(root, []byte("service/eu/host-02"), proof)
+		require.ErrorIs(t, err, ErrWhileProof)
+	}
+}
@@ -0,0 +1,19 @@
+package mptrie
+
+// HashNode is a reference to a node by its hash, it shows up in place of
+// children that were decoded from storage and not loaded yet.
+type HashNode []byte
+
+func (h HashNode) Hash() []byte {
+	return h
+}
+
+// Raw returns nil as a hash node is never encoded on its own, the parents
+// always reference it by the hash itself.
+func (h HashNode) Raw() []interface{} {
+	return nil
+}
+
+// resolver turns a node into its loaded form, hash nodes are replaced with the
+// node they reference while any other node is returned as it is.
+type resolver func(Node) (Node, error)
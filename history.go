@@ -0,0 +1,238 @@
+package mptrie
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+	"sync"
+)
+
+var (
+	ErrUnknownVersion = errors.New("version is not in the history")
+	ErrVersionOrder   = errors.New("version must be higher than the latest one")
+	ErrNotCompactor   = errors.New("node database cannot be compacted")
+	ErrSharedIndex    = errors.New("compaction would drop the versions kept in the node database")
+)
+
+// historyPrefix starts the keys of the versions, the index may be the node
+// database so the keys are also told apart from node hashes by their length
+var historyPrefix = []byte("mptrie-version-")
+
+// Compactor is a storage that can drop every node not reachable from roots,
+// like LogStorage
+type Compactor interface {
+	Compact(roots [][]byte, opts ...Option) (CompactionStats, error)
+}
+
+var _ Compactor = (*LogStorage)(nil)
+
+// History commits tries into a Database keyed by hash and records the root of
+// each commit under a version, so any version kept can be read and proved
+// later. Only the latest versions are kept when a retention is given, the
+// nodes of the dropped ones are freed by Compact. The versions are recorded
+// in index, which must not be the node database when it is compacted since
+// compaction only keeps nodes.
+type History struct {
+	db    Database
+	index Database
+	opts  []Option
+
+	// retain is the number of versions kept, 0 keeps every version
+	retain int
+
+	// commitLock keeps commits out while the nodes are compacted
+	commitLock sync.Mutex
+	lock       sync.RWMutex
+	versions   []uint64
+	roots      map[uint64][]byte
+}
+
+// NewHistory returns the history recorded in index over the nodes in db,
+// opts must match the ones of the tries committed to it
+func NewHistory(db, index Database, retain int, opts ...Option) (*History, error) {
+	h := &History{db: db, index: index, opts: opts, retain: retain, roots: make(map[uint64][]byte)}
+
+	it := index.NewIterator(historyPrefix, nil)
+	defer it.Release()
+
+	for it.Next() {
+		if len(it.Key()) != len(historyPrefix)+8 {
+			continue
+		}
+
+		version := binary.BigEndian.Uint64(it.Key()[len(historyPrefix):])
+		h.versions = append(h.versions, version)
+		h.roots[version] = copyBytes(it.Value())
+	}
+
+	return h, it.Error()
+}
+
+func historyKey(version uint64) []byte {
+	key := make([]byte, len(historyPrefix)+8)
+	copy(key, historyPrefix)
+	binary.BigEndian.PutUint64(key[len(historyPrefix):], version)
+	return key
+}
+
+// Commit stores the nodes of t and records its root under version, which
+// must be higher than every version recorded. The oldest versions beyond the
+// retention are dropped.
+func (h *History) Commit(version uint64, t *Trie) ([]byte, error) {
+	h.commitLock.Lock()
+	defer h.commitLock.Unlock()
+
+	h.lock.RLock()
+	latest, ok := h.latest()
+	h.lock.RUnlock()
+
+	if ok && version <= latest {
+		return nil, ErrVersionOrder
+	}
+
+	root, err := t.Commit(h.db)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.index.Put(historyKey(version), root); err != nil {
+		return nil, err
+	}
+
+	h.lock.Lock()
+	h.versions = append(h.versions, version)
+	h.roots[version] = root
+	prune := h.retain > 0 && len(h.versions) > h.retain
+	var oldest uint64
+	if prune {
+		oldest = h.versions[len(h.versions)-h.retain]
+	}
+	h.lock.Unlock()
+
+	if prune {
+		if err := h.Prune(oldest); err != nil {
+			return nil, err
+		}
+	}
+
+	return root, nil
+}
+
+func (h *History) latest() (uint64, bool) {
+	if len(h.versions) == 0 {
+		return 0, false
+	}
+
+	return h.versions[len(h.versions)-1], true
+}
+
+// Versions returns the versions kept, in ascending order
+func (h *History) Versions() []uint64 {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	return append([]uint64{}, h.versions...)
+}
+
+// Root returns the root recorded under version
+func (h *History) Root(version uint64) ([]byte, error) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	root, ok := h.roots[version]
+	if !ok {
+		return nil, ErrUnknownVersion
+	}
+
+	return copyBytes(root), nil
+}
+
+// Open returns the trie as it was at version
+func (h *History) Open(version uint64) (*Trie, error) {
+	root, err := h.Root(version)
+	if err != nil {
+		return nil, err
+	}
+
+	return OpenTrie(root, h.db, h.opts...), nil
+}
+
+// GetAt returns the value key had at version
+func (h *History) GetAt(version uint64, key []byte) ([]byte, bool, error) {
+	t, err := h.Open(version)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return t.TryGet(key)
+}
+
+// ProveAt writes into w the proof of the value key had at version, it is
+// checked against the root returned by Root.
+func (h *History) ProveAt(version uint64, key []byte, w KVWriter) error {
+	t, err := h.Open(version)
+	if err != nil {
+		return err
+	}
+
+	return CreateProof(key, t, w)
+}
+
+// Prune drops in a single batch every version lower than before, their nodes
+// stay in the node database until Compact runs.
+func (h *History) Prune(before uint64) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	n := sort.Search(len(h.versions), func(i int) bool {
+		return h.versions[i] >= before
+	})
+
+	err := writeBatch(h.index, func(w KVWriter) error {
+		for _, version := range h.versions[:n] {
+			if err := w.Delete(historyKey(version)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	for _, version := range h.versions[:n] {
+		delete(h.roots, version)
+	}
+
+	h.versions = append([]uint64{}, h.versions[n:]...)
+	return nil
+}
+
+// Compact frees the nodes only reachable from the dropped versions when the
+// node database is a Compactor, no commit runs meanwhile so every node
+// written belongs to a kept version. Compaction only keeps nodes, so it
+// returns ErrSharedIndex when the versions are recorded in the node database.
+func (h *History) Compact() (CompactionStats, error) {
+	gc, ok := h.db.(Compactor)
+	if !ok {
+		return CompactionStats{}, ErrNotCompactor
+	}
+
+	if h.index == h.db {
+		return CompactionStats{}, ErrSharedIndex
+	}
+
+	h.commitLock.Lock()
+	defer h.commitLock.Unlock()
+
+	h.lock.RLock()
+	roots := make([][]byte, 0, len(h.versions))
+	for _, version := range h.versions {
+		roots = append(roots, h.roots[version])
+	}
+	h.lock.RUnlock()
+
+	return gc.Compact(roots, h.opts...)
+}
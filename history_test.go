@@ -0,0 +1,161 @@
+package mptrie
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/require"
+
+	ethtrie "github.com/ethereum/go-ethereum/trie"
+)
+
+func commitHistory(t *testing.T, h *History, from, to uint64) {
+	trie := NewTrie()
+	if versions := h.Versions(); len(versions) > 0 {
+		var err error
+		trie, err = h.Open(versions[len(versions)-1])
+		require.NoError(t, err)
+	}
+
+	for version := from; version <= to; version++ {
+		for i := 0; i < 100; i++ {
+			key := []byte(fmt.Sprintf("account-%03d", i))
+			require.NoError(t, trie.Put(key, []byte(fmt.Sprintf("balance %d at %d", i, version))))
+		}
+
+		_, err := h.Commit(version, trie)
+		require.NoError(t, err)
+	}
+}
+
+func TestHistory_ShouldReadAndProveAnyVersion(t *testing.T) {
+	db, index := NewInMemoryStorage(), NewInMemoryStorage()
+	h, err := NewHistory(db, index, 0)
+	require.NoError(t, err)
+
+	commitHistory(t, h, 1, 5)
+
+	for version := uint64(1); version <= 5; version++ {
+		key := []byte("account-007")
+		want := []byte(fmt.Sprintf("balance 7 at %d", version))
+
+		v, ok, err := h.GetAt(version, key)
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, want, v)
+
+		proof := NewInMemoryStorage()
+		require.NoError(t, h.ProveAt(version, key, proof))
+
+		root, err := h.Root(version)
+		require.NoError(t, err)
+
+		v, err = ethtrie.VerifyProof(common.BytesToHash(root), key, proof)
+		require.NoError(t, err)
+		require.Equal(t, want, v)
+	}
+
+	_, _, err = h.GetAt(6, []byte("account-007"))
+	require.ErrorIs(t, err, ErrUnknownVersion)
+
+	_, err = h.Commit(5, NewTrie())
+	require.ErrorIs(t, err, ErrVersionOrder)
+
+	// the versions are loaded back from the index
+	h, err = NewHistory(db, index, 0)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{1, 2, 3, 4, 5}, h.Versions())
+
+	_, err = h.Compact()
+	require.ErrorIs(t, err, ErrNotCompactor)
+}
+
+func TestHistory_ShouldDropOldVersionsAndCompact(t *testing.T) {
+	db := openLogStorage(t, t.TempDir())
+	defer db.Close()
+
+	h, err := NewHistory(db, NewInMemoryStorage(), 3)
+	require.NoError(t, err)
+
+	commitHistory(t, h, 1, 6)
+	require.Equal(t, []uint64{4, 5, 6}, h.Versions())
+
+	old, err := h.Root(4)
+	require.NoError(t, err)
+
+	require.NoError(t, h.Prune(5))
+	require.Equal(t, []uint64{5, 6}, h.Versions())
+
+	_, err = h.Root(4)
+	require.ErrorIs(t, err, ErrUnknownVersion)
+
+	stats, err := h.Compact()
+	require.NoError(t, err)
+	require.Greater(t, stats.BytesReclaimed, int64(0))
+
+	// the nodes of the dropped versions are gone, the kept ones are intact
+	_, _, err = OpenTrie(old, db).TryGet([]byte("account-007"))
+	require.ErrorIs(t, err, KeyNotFound)
+
+	for _, version := range []uint64{5, 6} {
+		v, ok, err := h.GetAt(version, []byte("account-099"))
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, []byte(fmt.Sprintf("balance 99 at %d", version)), v)
+	}
+
+	commitHistory(t, h, 7, 7)
+	require.Equal(t, []uint64{5, 6, 7}, h.Versions())
+}
+
+func TestHistory_ShouldReopenWithTheIndexInTheNodeDatabase(t *testing.T) {
+	db := NewInMemoryStorage()
+	h, err := NewHistory(db, db, 0)
+	require.NoError(t, err)
+
+	// enough commits for node hashes to start with any byte
+	commitHistory(t, h, 1, 200)
+	require.NoError(t, db.Put(append(copyBytes(historyPrefix), 0x01), []byte("not a version")))
+
+	h, err = NewHistory(db, db, 0)
+	require.NoError(t, err)
+
+	versions := h.Versions()
+	require.Len(t, versions, 200)
+	require.Equal(t, uint64(1), versions[0])
+	require.Equal(t, uint64(200), versions[199])
+
+	commitHistory(t, h, 201, 201)
+
+	v, ok, err := h.GetAt(201, []byte("account-007"))
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, []byte("balance 7 at 201"), v)
+}
+
+func TestHistory_CompactShouldKeepAnIndexSharedWithTheNodes(t *testing.T) {
+	dir := t.TempDir()
+	db := openLogStorage(t, dir)
+
+	h, err := NewHistory(db, db, 2)
+	require.NoError(t, err)
+
+	commitHistory(t, h, 1, 5)
+
+	_, err = h.Compact()
+	require.ErrorIs(t, err, ErrSharedIndex)
+	require.NoError(t, db.Close())
+
+	db = openLogStorage(t, dir)
+	defer db.Close()
+
+	h, err = NewHistory(db, db, 2)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{4, 5}, h.Versions())
+
+	v, ok, err := h.GetAt(5, []byte("account-042"))
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, []byte("balance 42 at 5"), v)
+}
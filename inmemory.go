@@ -1,7 +1,9 @@
 package mptrie
 
 import (
+	"bytes"
 	"errors"
+	"sort"
 	"sync"
 )
 
@@ -10,6 +12,8 @@ var (
 	NotInitialized = errors.New("database not initialized")
 )
 
+var _ Database = (*InMemoryStorage)(nil)
+
 type InMemoryStorage struct {
 	kv   map[string][]byte
 	lock sync.RWMutex
@@ -78,3 +82,139 @@ func (s *InMemoryStorage) Get(key []byte) ([]byte, error) {
 
 	return nil, KeyNotFound
 }
+
+// Close drops every stored key, the storage cannot be used afterwards
+func (s *InMemoryStorage) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.kv = nil
+	return nil
+}
+
+func (s *InMemoryStorage) NewBatch() Batch {
+	return &memoryBatch{db: s}
+}
+
+// NewIterator walks over a snapshot of the keys taken when it is created,
+// writes made afterwards are not seen.
+func (s *InMemoryStorage) NewIterator(prefix, start []byte) Iterator {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if s.kv == nil {
+		return &memoryIterator{err: NotInitialized}
+	}
+
+	from := append(append([]byte{}, prefix...), start...)
+	it := &memoryIterator{index: -1}
+
+	for k, v := range s.kv {
+		key := []byte(k)
+		if bytes.HasPrefix(key, prefix) && bytes.Compare(key, from) >= 0 {
+			it.keys = append(it.keys, key)
+			it.values = append(it.values, append([]byte{}, v...))
+		}
+	}
+
+	sort.Sort(it)
+	return it
+}
+
+type memoryOp struct {
+	key, value []byte
+	delete     bool
+}
+
+type memoryBatch struct {
+	db  *InMemoryStorage
+	ops []memoryOp
+}
+
+func (b *memoryBatch) Put(key, value []byte) error {
+	b.ops = append(b.ops, memoryOp{key: copyBytes(key), value: copyBytes(value)})
+	return nil
+}
+
+func (b *memoryBatch) Delete(key []byte) error {
+	b.ops = append(b.ops, memoryOp{key: copyBytes(key), delete: true})
+	return nil
+}
+
+// Write applies the batch holding the storage lock, so readers see either
+// none or all of its writes
+func (b *memoryBatch) Write() error {
+	b.db.lock.Lock()
+	defer b.db.lock.Unlock()
+
+	if b.db.kv == nil {
+		return NotInitialized
+	}
+
+	for _, op := range b.ops {
+		if op.delete {
+			delete(b.db.kv, string(op.key))
+		} else {
+			b.db.kv[string(op.key)] = op.value
+		}
+	}
+
+	return nil
+}
+
+func (b *memoryBatch) Reset() {
+	b.ops = b.ops[:0]
+}
+
+type memoryIterator struct {
+	keys, values [][]byte
+	index        int
+	err          error
+}
+
+func (it *memoryIterator) Next() bool {
+	if it.index+1 >= len(it.keys) {
+		it.index = len(it.keys)
+		return false
+	}
+
+	it.index++
+	return true
+}
+
+func (it *memoryIterator) Key() []byte {
+	if it.index < 0 || it.index >= len(it.keys) {
+		return nil
+	}
+
+	return it.keys[it.index]
+}
+
+func (it *memoryIterator) Value() []byte {
+	if it.index < 0 || it.index >= len(it.values) {
+		return nil
+	}
+
+	return it.values[it.index]
+}
+
+func (it *memoryIterator) Error() error {
+	return it.err
+}
+
+func (it *memoryIterator) Release() {
+	it.keys, it.values = nil, nil
+}
+
+func (it *memoryIterator) Len() int {
+	return len(it.keys)
+}
+
+func (it *memoryIterator) Less(i, j int) bool {
+	return bytes.Compare(it.keys[i], it.keys[j]) < 0
+}
+
+func (it *memoryIterator) Swap(i, j int) {
+	it.keys[i], it.keys[j] = it.keys[j], it.keys[i]
+	it.values[i], it.values[j] = it.values[j], it.values[i]
+}
@@ -1,26 +1,24 @@
 package mptrie
 
-import (
-	"github.com/ethereum/go-ethereum/crypto"
-)
-
 type LeafNode struct {
 	Path  []Nibble
 	Value []byte
+
+	// ValueHash is set instead of Value when the node was decoded without
+	// its value, see WithHashedValues
+	ValueHash []byte
 }
 
 func (l LeafNode) Hash() []byte {
-	return crypto.Keccak256(l.Serialize())
+	return defaultEncoder.hash(&l)
 }
 
 func (l LeafNode) Serialize() []byte {
-	return Serialize(l)
+	return defaultEncoder.serialize(&l)
 }
 
 func (l LeafNode) Raw() []interface{} {
-	path := ToBytes(ToPrefixed(l.Path, true))
-	raw := []interface{}{path, l.Value}
-	return raw
+	return defaultEncoder.raw(&l)
 }
 
 func NewLeafNodeFromNibbles(nibbles []Nibble, value []byte) *LeafNode {
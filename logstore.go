@@ -0,0 +1,572 @@
+package mptrie
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrCorruptedLog = errors.New("log storage record is corrupted")
+)
+
+const (
+	logSegmentExt = ".log"
+	// every record starts with the CRC32 and the length of its payload
+	logHeaderSize = 8
+
+	logOpPut    byte = 1
+	logOpDelete byte = 2
+
+	defaultSegmentSize = 64 << 20
+)
+
+var _ Database = (*LogStorage)(nil)
+
+// LogStorage is a Database kept in append-only segment files inside a
+// directory. Every batch is appended as a single record checked by a CRC and
+// synced to disk before Write returns, the index of where each value lives is
+// kept in memory and rebuilt by replaying the segments when the storage is
+// opened. A record left half written by a crash is cut off the end of the
+// last segment, so a batch is either fully stored or not at all.
+type LogStorage struct {
+	dir         string
+	segmentSize int64
+
+	// writeLock orders the writers, lock guards the index and the segments
+	// so readers only wait for the index to be updated, compactLock lets a
+	// single compaction run at a time
+	writeLock   sync.Mutex
+	lock        sync.RWMutex
+	compactLock sync.Mutex
+
+	index    map[string]logLocation
+	segments map[int]*logSegment
+	active   *logSegment
+	closed   bool
+}
+
+// logLocation is where the value of a key is stored
+type logLocation struct {
+	segment int
+	offset  int64
+	size    int
+}
+
+type logSegment struct {
+	id   int
+	file *os.File
+	size int64
+}
+
+// LogOption changes how a LogStorage is opened
+type LogOption func(*LogStorage)
+
+// WithSegmentSize starts a new segment once the active one reaches n bytes,
+// the default is 64MiB. A single batch is never split between segments.
+func WithSegmentSize(n int64) LogOption {
+	return func(s *LogStorage) {
+		s.segmentSize = n
+	}
+}
+
+// OpenLogStorage opens the log storage in dir, creating it when it does not
+// exist, and rebuilds its index.
+func OpenLogStorage(dir string, opts ...LogOption) (*LogStorage, error) {
+	s := &LogStorage{
+		dir:         dir,
+		segmentSize: defaultSegmentSize,
+		index:       make(map[string]logLocation),
+		segments:    make(map[int]*logSegment),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	ids, err := s.segmentIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, id := range ids {
+		seg, err := s.openSegment(id)
+		if err != nil {
+			s.closeSegments()
+			return nil, err
+		}
+
+		s.segments[id] = seg
+		if err := s.replay(seg, i == len(ids)-1); err != nil {
+			s.closeSegments()
+			return nil, err
+		}
+
+		s.active = seg
+	}
+
+	if s.active == nil {
+		if s.active, err = s.createSegment(1); err != nil {
+			return nil, err
+		}
+
+		s.segments[s.active.id] = s.active
+	}
+
+	return s, nil
+}
+
+// segmentIDs lists the segments found in the directory in the order they
+// were written
+func (s *LogStorage) segmentIDs() ([]int, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []int
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, logSegmentExt) {
+			continue
+		}
+
+		id, err := strconv.Atoi(strings.TrimSuffix(name, logSegmentExt))
+		if err != nil {
+			continue
+		}
+
+		ids = append(ids, id)
+	}
+
+	sort.Ints(ids)
+	return ids, nil
+}
+
+func sortedSegmentIDs(segments map[int]*logSegment) []int {
+	ids := make([]int, 0, len(segments))
+	for id := range segments {
+		ids = append(ids, id)
+	}
+
+	sort.Ints(ids)
+	return ids
+}
+
+func (s *LogStorage) segmentPath(id int) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%08d%s", id, logSegmentExt))
+}
+
+func (s *LogStorage) openSegment(id int) (*logSegment, error) {
+	file, err := os.OpenFile(s.segmentPath(id), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &logSegment{id: id, file: file, size: info.Size()}, nil
+}
+
+// createSegment adds an empty segment and syncs the directory so the new
+// file survives a crash, the caller adds it to the segments
+func (s *LogStorage) createSegment(id int) (*logSegment, error) {
+	seg, err := s.openSegment(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syncDir(s.dir); err != nil {
+		seg.file.Close()
+		return nil, err
+	}
+
+	return seg, nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+
+	defer d.Close()
+	return d.Sync()
+}
+
+// replay applies the records of seg to the index. A record that is cut short
+// or fails its CRC in the last segment is a write interrupted by a crash, the
+// segment is truncated there. Anywhere else it is corruption.
+func (s *LogStorage) replay(seg *logSegment, last bool) error {
+	r := bufio.NewReader(io.NewSectionReader(seg.file, 0, seg.size))
+	var offset int64
+
+	for offset < seg.size {
+		payload, ok := readLogRecord(r, seg.size-offset)
+		if ok {
+			ok = s.apply(seg.id, offset+logHeaderSize, payload)
+		}
+
+		if !ok {
+			if !last {
+				return fmt.Errorf("%w: segment %d at offset %d", ErrCorruptedLog, seg.id, offset)
+			}
+
+			if err := seg.file.Truncate(offset); err != nil {
+				return err
+			}
+
+			seg.size = offset
+			return seg.file.Sync()
+		}
+
+		offset += logHeaderSize + int64(len(payload))
+	}
+
+	return nil
+}
+
+// readLogRecord reads the next record of at most available bytes, ok is false
+// when the record is incomplete or does not match its CRC
+func readLogRecord(r io.Reader, available int64) (payload []byte, ok bool) {
+	var header [logHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, false
+	}
+
+	sum := binary.LittleEndian.Uint32(header[0:4])
+	length := int64(binary.LittleEndian.Uint32(header[4:8]))
+	if length > available-logHeaderSize {
+		return nil, false
+	}
+
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, false
+	}
+
+	return payload, crc32.ChecksumIEEE(payload) == sum
+}
+
+// apply updates the index with the operations of a record payload that
+// starts at offset in the segment, it reports whether the payload is valid
+func (s *LogStorage) apply(segment int, offset int64, payload []byte) bool {
+	return walkLogPayload(payload, func(op byte, key []byte, valueAt, size int) {
+		if op == logOpDelete {
+			delete(s.index, string(key))
+			return
+		}
+
+		s.index[string(key)] = logLocation{segment: segment, offset: offset + int64(valueAt), size: size}
+	})
+}
+
+// walkLogPayload calls fn for every operation of a record payload with the
+// position and size of its value, it reports whether the payload is valid
+func walkLogPayload(payload []byte, fn func(op byte, key []byte, valueAt, size int)) bool {
+	pos := 0
+
+	readBytes := func() (int, int, bool) {
+		n, read := binary.Uvarint(payload[pos:])
+		if read <= 0 || n > uint64(len(payload)-pos-read) {
+			return 0, 0, false
+		}
+
+		at := pos + read
+		pos = at + int(n)
+		return at, int(n), true
+	}
+
+	for pos < len(payload) {
+		op := payload[pos]
+		pos++
+
+		keyAt, keySize, ok := readBytes()
+		if !ok {
+			return false
+		}
+
+		key := payload[keyAt : keyAt+keySize]
+
+		switch op {
+		case logOpDelete:
+			fn(op, key, 0, 0)
+		case logOpPut:
+			valueAt, size, ok := readBytes()
+			if !ok {
+				return false
+			}
+
+			fn(op, key, valueAt, size)
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+func (s *LogStorage) Has(key []byte) (bool, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if s.closed {
+		return false, NotInitialized
+	}
+
+	_, ok := s.index[string(key)]
+	return ok, nil
+}
+
+func (s *LogStorage) Get(key []byte) ([]byte, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if s.closed {
+		return nil, NotInitialized
+	}
+
+	loc, ok := s.index[string(key)]
+	if !ok {
+		return nil, KeyNotFound
+	}
+
+	return s.read(loc)
+}
+
+// read loads the value at loc, the caller holds the read lock
+func (s *LogStorage) read(loc logLocation) ([]byte, error) {
+	value := make([]byte, loc.size)
+	if _, err := s.segments[loc.segment].file.ReadAt(value, loc.offset); err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
+
+func (s *LogStorage) Put(key, value []byte) error {
+	b := s.NewBatch()
+	if err := b.Put(key, value); err != nil {
+		return err
+	}
+
+	return b.Write()
+}
+
+func (s *LogStorage) Delete(key []byte) error {
+	b := s.NewBatch()
+	if err := b.Delete(key); err != nil {
+		return err
+	}
+
+	return b.Write()
+}
+
+func (s *LogStorage) NewBatch() Batch {
+	return &logBatch{db: s}
+}
+
+// write appends payload as one record and syncs it, the index is only
+// updated once the record is on disk
+func (s *LogStorage) write(payload []byte) error {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	s.lock.RLock()
+	closed, seg := s.closed, s.active
+	s.lock.RUnlock()
+
+	if closed {
+		return NotInitialized
+	}
+
+	record := make([]byte, logHeaderSize, logHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(record[0:4], crc32.ChecksumIEEE(payload))
+	binary.LittleEndian.PutUint32(record[4:8], uint32(len(payload)))
+	record = append(record, payload...)
+
+	if seg.size > 0 && seg.size+int64(len(record)) > s.segmentSize {
+		next, err := s.createSegment(seg.id + 1)
+		if err != nil {
+			return err
+		}
+
+		s.lock.Lock()
+		s.segments[next.id] = next
+		s.active = next
+		s.lock.Unlock()
+		seg = next
+	}
+
+	if _, err := seg.file.WriteAt(record, seg.size); err != nil {
+		seg.file.Truncate(seg.size)
+		return err
+	}
+
+	if err := seg.file.Sync(); err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.apply(seg.id, seg.size+logHeaderSize, payload)
+	seg.size += int64(len(record))
+	return nil
+}
+
+// NewIterator walks over a snapshot of the keys taken when it is created, the
+// values are read as the iterator moves so keys deleted in the meantime are
+// skipped.
+func (s *LogStorage) NewIterator(prefix, start []byte) Iterator {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if s.closed {
+		return &logIterator{err: NotInitialized}
+	}
+
+	from := append(append([]byte{}, prefix...), start...)
+	it := &logIterator{db: s}
+
+	for k := range s.index {
+		key := []byte(k)
+		if bytes.HasPrefix(key, prefix) && bytes.Compare(key, from) >= 0 {
+			it.keys = append(it.keys, key)
+		}
+	}
+
+	sort.Slice(it.keys, func(i, j int) bool {
+		return bytes.Compare(it.keys[i], it.keys[j]) < 0
+	})
+
+	return it
+}
+
+// Close closes the segment files, the storage cannot be used afterwards
+func (s *LogStorage) Close() error {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return nil
+	}
+
+	s.closed = true
+	return s.closeSegments()
+}
+
+func (s *LogStorage) closeSegments() error {
+	var err error
+	for _, seg := range s.segments {
+		if closeErr := seg.file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
+
+type logBatch struct {
+	db      *LogStorage
+	payload []byte
+}
+
+func (b *logBatch) Put(key, value []byte) error {
+	b.payload = append(b.payload, logOpPut)
+	b.payload = appendLogBytes(b.payload, key)
+	b.payload = appendLogBytes(b.payload, value)
+	return nil
+}
+
+func (b *logBatch) Delete(key []byte) error {
+	b.payload = append(b.payload, logOpDelete)
+	b.payload = appendLogBytes(b.payload, key)
+	return nil
+}
+
+func (b *logBatch) Write() error {
+	if len(b.payload) == 0 {
+		return nil
+	}
+
+	return b.db.write(b.payload)
+}
+
+func (b *logBatch) Reset() {
+	b.payload = b.payload[:0]
+}
+
+func appendLogBytes(buf, b []byte) []byte {
+	var size [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(size[:], uint64(len(b)))
+	return append(append(buf, size[:n]...), b...)
+}
+
+type logIterator struct {
+	db    *LogStorage
+	keys  [][]byte
+	key   []byte
+	value []byte
+	err   error
+}
+
+func (it *logIterator) Next() bool {
+	for it.err == nil && len(it.keys) > 0 {
+		key := it.keys[0]
+		it.keys = it.keys[1:]
+
+		value, err := it.db.Get(key)
+		if errors.Is(err, KeyNotFound) {
+			continue
+		}
+
+		if err != nil {
+			it.err = err
+			break
+		}
+
+		it.key, it.value = key, value
+		return true
+	}
+
+	it.key, it.value = nil, nil
+	return false
+}
+
+func (it *logIterator) Key() []byte {
+	return it.key
+}
+
+func (it *logIterator) Value() []byte {
+	return it.value
+}
+
+func (it *logIterator) Error() error {
+	return it.err
+}
+
+func (it *logIterator) Release() {
+	it.keys, it.key, it.value = nil, nil, nil
+}
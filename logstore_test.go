@@ -0,0 +1,165 @@
+package mptrie
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func openLogStorage(t *testing.T, dir string, opts ...LogOption) *LogStorage {
+	s, err := OpenLogStorage(dir, opts...)
+	require.NoError(t, err)
+	return s
+}
+
+func segmentFiles(t *testing.T, dir string) []string {
+	files, err := filepath.Glob(filepath.Join(dir, "*"+logSegmentExt))
+	require.NoError(t, err)
+	return files
+}
+
+func TestLogStorage_ShouldSurviveReopen(t *testing.T) {
+	dir := t.TempDir()
+	s := openLogStorage(t, dir)
+
+	require.NoError(t, s.Put([]byte("a"), []byte("1")))
+	require.NoError(t, s.Put([]byte("b"), []byte("2")))
+	require.NoError(t, s.Put([]byte("a"), []byte("3")))
+	require.NoError(t, s.Put([]byte("empty"), []byte{}))
+	require.NoError(t, s.Delete([]byte("b")))
+
+	batch := s.NewBatch()
+	require.NoError(t, batch.Put([]byte("c"), []byte("4")))
+	require.NoError(t, batch.Delete([]byte("a")))
+	require.NoError(t, batch.Write())
+	require.NoError(t, s.Close())
+
+	_, err := s.Get([]byte("c"))
+	require.ErrorIs(t, err, NotInitialized)
+
+	s = openLogStorage(t, dir)
+	defer s.Close()
+
+	for key, expected := range map[string][]byte{"a": nil, "b": nil, "c": []byte("4"), "empty": {}} {
+		v, err := s.Get([]byte(key))
+		if expected == nil {
+			require.ErrorIs(t, err, KeyNotFound, key)
+			continue
+		}
+
+		require.NoError(t, err)
+		require.Equal(t, expected, v, key)
+	}
+
+	require.Equal(t, []string{"c", "empty"}, collectIterator(t, s.NewIterator(nil, nil)))
+}
+
+func TestLogStorage_ShouldTruncateTornTail(t *testing.T) {
+	dir := t.TempDir()
+	s := openLogStorage(t, dir)
+
+	require.NoError(t, s.Put([]byte("a"), []byte("1")))
+	size := s.active.size
+
+	batch := s.NewBatch()
+	for i := 0; i < 10; i++ {
+		require.NoError(t, batch.Put([]byte(fmt.Sprintf("k%d", i)), []byte("v")))
+	}
+
+	require.NoError(t, batch.Write())
+	require.NoError(t, s.Close())
+
+	path := segmentFiles(t, dir)[0]
+	full, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	// the batch record half written: none of its keys are kept
+	require.NoError(t, os.WriteFile(path, full[:len(full)-5], 0o644))
+
+	s = openLogStorage(t, dir)
+	_, err = s.Get([]byte("k0"))
+	require.ErrorIs(t, err, KeyNotFound)
+
+	v, err := s.Get([]byte("a"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("1"), v)
+	require.Equal(t, size, s.active.size)
+
+	// writes continue after the truncated record
+	require.NoError(t, s.Put([]byte("b"), []byte("2")))
+	require.NoError(t, s.Close())
+
+	// a record whose bytes changed fails its CRC
+	full, err = os.ReadFile(path)
+	require.NoError(t, err)
+	full[len(full)-1] ^= 0xff
+	require.NoError(t, os.WriteFile(path, full, 0o644))
+
+	s = openLogStorage(t, dir)
+	defer s.Close()
+
+	_, err = s.Get([]byte("b"))
+	require.ErrorIs(t, err, KeyNotFound)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, size, info.Size())
+}
+
+func TestLogStorage_ShouldRotateSegments(t *testing.T) {
+	dir := t.TempDir()
+	s := openLogStorage(t, dir, WithSegmentSize(256))
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, s.Put([]byte(fmt.Sprintf("key-%03d", i)), []byte(fmt.Sprintf("value-%d", i))))
+	}
+
+	require.NoError(t, s.Close())
+	require.Greater(t, len(segmentFiles(t, dir)), 5)
+
+	s = openLogStorage(t, dir, WithSegmentSize(256))
+	for i := 0; i < 100; i++ {
+		v, err := s.Get([]byte(fmt.Sprintf("key-%03d", i)))
+		require.NoError(t, err)
+		require.Equal(t, []byte(fmt.Sprintf("value-%d", i)), v)
+	}
+
+	require.Len(t, collectIterator(t, s.NewIterator([]byte("key-0"), []byte("5"))), 50)
+	require.NoError(t, s.Close())
+
+	// corruption before the last segment is not a torn write
+	first := segmentFiles(t, dir)[0]
+	data, err := os.ReadFile(first)
+	require.NoError(t, err)
+	data[logHeaderSize] ^= 0xff
+	require.NoError(t, os.WriteFile(first, data, 0o644))
+
+	_, err = OpenLogStorage(dir)
+	require.ErrorIs(t, err, ErrCorruptedLog)
+}
+
+func TestLogStorage_ShouldKeepCommittedTries(t *testing.T) {
+	dir := t.TempDir()
+	s := openLogStorage(t, dir)
+
+	trie := buildTrie(t, namespacedKeys)
+	root, err := trie.Commit(s)
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+
+	s = openLogStorage(t, dir)
+	defer s.Close()
+
+	opened := OpenTrie(root, s)
+	require.Equal(t, root, opened.Hash())
+
+	for _, k := range namespacedKeys {
+		v, ok, err := opened.TryGet([]byte(k))
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, []byte("value of "+k), v)
+	}
+}
@@ -0,0 +1,89 @@
+package mptrie
+
+import (
+	"bytes"
+	"errors"
+)
+
+var (
+	ErrMergeConflict = errors.New("key changed differently on both sides of the merge")
+)
+
+// Conflict is a key changed in different ways by ours and theirs, a nil value
+// means the key does not exist on that side.
+type Conflict struct {
+	Key    []byte
+	Base   []byte
+	Ours   []byte
+	Theirs []byte
+}
+
+// ConflictResolver picks the value a conflicting key gets in the merged trie,
+// returning a nil value removes the key.
+type ConflictResolver func(Conflict) ([]byte, error)
+
+// Merge folds the changes made from base to theirs into a copy of ours. The
+// copy shares every node of ours, so the subtrees ours did not change are
+// reused as they are, and the diff from base to theirs skips every subtree
+// whose hash did not change. Keys changed differently on both sides are given
+// to resolve, when it is nil the merge fails with ErrMergeConflict.
+func Merge(base, ours, theirs *Trie, resolve ConflictResolver) (*Trie, error) {
+	if bytes.Equal(ours.Hash(), base.Hash()) {
+		return theirs.Copy(), nil
+	}
+
+	merged := ours.Copy()
+	if bytes.Equal(theirs.Hash(), base.Hash()) {
+		return merged, nil
+	}
+
+	err := Diff(base, theirs, func(c Change) error {
+		current, ok, err := ours.TryGet(c.Key)
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			current = nil
+		}
+
+		switch {
+		// ours left the key untouched, the change from theirs wins
+		case sameValue(current, c.From):
+			return applyValue(merged, c.Key, c.To)
+		// both sides made the same change
+		case sameValue(current, c.To):
+			return nil
+		}
+
+		if resolve == nil {
+			return ErrMergeConflict
+		}
+
+		value, err := resolve(Conflict{Key: c.Key, Base: c.From, Ours: current, Theirs: c.To})
+		if err != nil {
+			return err
+		}
+
+		return applyValue(merged, c.Key, value)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return merged, nil
+}
+
+// sameValue compares two values telling a missing key (nil) apart
+func sameValue(a, b []byte) bool {
+	return (a == nil) == (b == nil) && bytes.Equal(a, b)
+}
+
+func applyValue(t *Trie, key, value []byte) error {
+	if value == nil {
+		return t.Delete(key)
+	}
+
+	return t.Put(key, value)
+}
@@ -0,0 +1,124 @@
+package mptrie
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMerge_ShouldApplyChangesFromBothSides(t *testing.T) {
+	base := buildTrie(t, namespacedKeys)
+
+	ours := base.Copy()
+	require.NoError(t, ours.Put([]byte("accounts.code"), []byte("code")))
+	require.NoError(t, ours.Delete([]byte("block.header")))
+	require.NoError(t, ours.Put([]byte("transfer.to"), []byte("same")))
+
+	theirs := base.Copy()
+	require.NoError(t, theirs.Put([]byte("block.number"), []byte("2")))
+	require.NoError(t, theirs.Delete([]byte("accounts.nonce")))
+	require.NoError(t, theirs.Put([]byte("transfer.to"), []byte("same")))
+
+	// forks share nodes with base, which must stay untouched
+	require.Equal(t, buildTrie(t, namespacedKeys).Hash(), base.Hash())
+
+	merged, err := Merge(base, ours, theirs, nil)
+	require.NoError(t, err)
+
+	expected := base.Copy()
+	require.NoError(t, expected.Put([]byte("accounts.code"), []byte("code")))
+	require.NoError(t, expected.Delete([]byte("block.header")))
+	require.NoError(t, expected.Put([]byte("block.number"), []byte("2")))
+	require.NoError(t, expected.Delete([]byte("accounts.nonce")))
+	require.NoError(t, expected.Put([]byte("transfer.to"), []byte("same")))
+
+	require.Equal(t, expected.Hash(), merged.Hash())
+	require.NotEqual(t, ours.Hash(), merged.Hash())
+}
+
+func TestMerge_WhenOneSideIsUnchanged(t *testing.T) {
+	base := buildTrie(t, namespacedKeys)
+	theirs := base.Copy()
+	require.NoError(t, theirs.Put([]byte("block.number"), []byte("2")))
+
+	merged, err := Merge(base, base.Copy(), theirs, nil)
+	require.NoError(t, err)
+	require.Equal(t, theirs.Hash(), merged.Hash())
+
+	merged, err = Merge(base, theirs, base.Copy(), nil)
+	require.NoError(t, err)
+	require.Equal(t, theirs.Hash(), merged.Hash())
+}
+
+func TestMerge_ShouldReportConflicts(t *testing.T) {
+	base := buildTrie(t, namespacedKeys)
+
+	ours := base.Copy()
+	require.NoError(t, ours.Put([]byte("block.number"), []byte("ours")))
+	require.NoError(t, ours.Delete([]byte("accounts.nonce")))
+
+	theirs := base.Copy()
+	require.NoError(t, theirs.Put([]byte("block.number"), []byte("theirs")))
+	require.NoError(t, theirs.Put([]byte("accounts.nonce"), []byte("theirs")))
+
+	_, err := Merge(base, ours, theirs, nil)
+	require.ErrorIs(t, err, ErrMergeConflict)
+
+	var conflicts []Conflict
+	merged, err := Merge(base, ours, theirs, func(c Conflict) ([]byte, error) {
+		conflicts = append(conflicts, c)
+		return c.Theirs, nil
+	})
+	require.NoError(t, err)
+
+	expected := []Conflict{
+		{
+			Key:    []byte("accounts.nonce"),
+			Base:   []byte("value of accounts.nonce"),
+			Ours:   nil,
+			Theirs: []byte("theirs"),
+		},
+		{
+			Key:    []byte("block.number"),
+			Base:   []byte("value of block.number"),
+			Ours:   []byte("ours"),
+			Theirs: []byte("theirs"),
+		},
+	}
+	require.Equal(t, expected, conflicts)
+	require.Equal(t, theirs.Hash(), merged.Hash())
+
+	merged, err = Merge(base, ours, theirs, func(c Conflict) ([]byte, error) {
+		return nil, nil
+	})
+	require.NoError(t, err)
+
+	_, ok := merged.Get([]byte("block.number"))
+	require.False(t, ok)
+
+	stop := errors.New("stop")
+	_, err = Merge(base, ours, theirs, func(c Conflict) ([]byte, error) {
+		return nil, stop
+	})
+	require.ErrorIs(t, err, stop)
+}
+
+func TestCopy_ShouldNotShareChanges(t *testing.T) {
+	trie := buildTrie(t, namespacedKeys)
+	before := trie.Hash()
+
+	fork := trie.Copy()
+	require.NoError(t, fork.Put([]byte("accounts.balance"), []byte("0")))
+	require.NoError(t, fork.Delete([]byte("transfer.input")))
+	_, err := fork.DeletePrefix([]byte("block"))
+	require.NoError(t, err)
+	_, err = fork.DeleteRange([]byte("accounts.a"), []byte("accounts.b"))
+	require.NoError(t, err)
+
+	require.Equal(t, before, trie.Hash())
+
+	v, ok := trie.Get([]byte("accounts.balance"))
+	require.True(t, ok)
+	require.Equal(t, []byte("value of accounts.balance"), v)
+}
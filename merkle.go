@@ -0,0 +1,36 @@
+package mptrie
+
+import "fmt"
+
+// MerkleTrie is the surface shared by the hexary Trie and the BinaryTrie, so
+// the kind of commitment can be picked by configuration.
+type MerkleTrie interface {
+	Get(key []byte) ([]byte, bool)
+	Put(key, value []byte) error
+	Delete(key []byte) error
+	Hash() []byte
+	CreateProof(key []byte, w KVWriter) error
+}
+
+var (
+	_ MerkleTrie = (*Trie)(nil)
+	_ MerkleTrie = (*BinaryTrie)(nil)
+)
+
+// NewMerkleTrie builds an empty trie with the given radix, 16 for Trie and 2
+// for BinaryTrie.
+func NewMerkleTrie(radix int, opts ...Option) (MerkleTrie, error) {
+	switch radix {
+	case 16:
+		return NewTrie(opts...), nil
+	case 2:
+		return NewBinaryTrie(opts...), nil
+	}
+
+	return nil, fmt.Errorf("unsupported trie radix %d", radix)
+}
+
+// CreateProof writes into w the nodes that prove key is in the trie
+func (t *Trie) CreateProof(key []byte, w KVWriter) error {
+	return CreateProof(key, t, w)
+}
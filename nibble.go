@@ -71,3 +71,45 @@ func PrefixMatchedLen(n []Nibble, b []Nibble) (matched int) {
 
 	return
 }
+
+// HasNibblePrefix reports whether ns begins with prefix.
+func HasNibblePrefix(ns []Nibble, prefix []Nibble) bool {
+	return len(ns) >= len(prefix) && PrefixMatchedLen(ns, prefix) == len(prefix)
+}
+
+// CompareNibbles compares two nibble paths lexicographically, the result is
+// 0 if a == b, -1 if a < b and +1 if a > b. Since every byte turns into two
+// nibbles, the order is the same as comparing the original keys.
+func CompareNibbles(a []Nibble, b []Nibble) int {
+	matched := PrefixMatchedLen(a, b)
+
+	switch {
+	case matched < len(a) && matched < len(b):
+		if a[matched] < b[matched] {
+			return -1
+		}
+		return 1
+	case len(a) < len(b):
+		return -1
+	case len(a) > len(b):
+		return 1
+	}
+
+	return 0
+}
+
+// ConcatNibbles returns a new slice holding every given path in order, the
+// inputs are never aliased by the result.
+func ConcatNibbles(paths ...[]Nibble) []Nibble {
+	size := 0
+	for _, p := range paths {
+		size += len(p)
+	}
+
+	ns := make([]Nibble, 0, size)
+	for _, p := range paths {
+		ns = append(ns, p...)
+	}
+
+	return ns
+}
@@ -2,8 +2,6 @@ package mptrie
 
 import (
 	"encoding/hex"
-
-	"github.com/ethereum/go-ethereum/rlp"
 )
 
 var (
@@ -24,19 +22,12 @@ func Hash(n Node) []byte {
 	return n.Hash()
 }
 
-func Serialize(n Node) []byte {
-	var raw interface{}
-
-	if n == nil {
-		raw = EmptyNodeRaw
-	} else {
-		raw = n.Raw()
-	}
-
-	b, err := rlp.EncodeToBytes(raw)
-	if err != nil {
-		panic(err)
-	}
+// Reference returns how a parent node refers to n, nodes whose encoding has 32
+// bytes or more are referenced by their hash while smaller ones are embedded.
+func Reference(n Node) interface{} {
+	return rlpCodec{}.reference(n, defaultEncoder.reference)
+}
 
-	return b
+func Serialize(n Node) []byte {
+	return defaultEncoder.serialize(n)
 }
@@ -0,0 +1,205 @@
+package mptrie
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+)
+
+var (
+	ErrDeleteNotSupported = errors.New("nodes are only removed by Dereference")
+)
+
+var _ KVWriter = (*NodeDatabase)(nil)
+var _ NodeReader = (*NodeDatabase)(nil)
+var _ ValueWriter = (*NodeDatabase)(nil)
+
+// NodeDatabase keeps the nodes committed by tries in memory until they are
+// flushed to disk, counting how many parents reference each of them. Tries
+// are committed to it with Commit and opened from it with OpenTrie, nodes not
+// in memory are read from disk. Roots retained with Reference are kept until
+// Dereference releases them, along with every node only they reached.
+type NodeDatabase struct {
+	disk ValueStore
+	e    encoder
+
+	lock  sync.RWMutex
+	nodes map[string]*cachedNode
+	// flushList holds the keys in the order they were written, children are
+	// always written before their parents
+	flushList *list.List
+	size      int
+}
+
+type cachedNode struct {
+	blob []byte
+	// children are the nodes and values referenced by hash from blob
+	children [][]byte
+	parents  int
+	elem     *list.Element
+}
+
+// NewNodeDatabase returns an empty node database over disk, opts must match
+// the ones of the tries committed to it, only the codec is used to find the
+// children of each node.
+func NewNodeDatabase(disk ValueStore, opts ...Option) *NodeDatabase {
+	return &NodeDatabase{
+		disk:      disk,
+		e:         NewTrie(opts...).encoder(),
+		nodes:     make(map[string]*cachedNode),
+		flushList: list.New(),
+	}
+}
+
+// Put stores a node and adds a reference from it to each of its children
+// still in memory, values referenced by hash are stored with PutValue. Keys
+// already stored are left as they are.
+func (db *NodeDatabase) Put(key, value []byte) error {
+	n, err := db.e.decode(value)
+	if err != nil {
+		return err
+	}
+
+	var children, values [][]byte
+	children, values = appendReferences(children, values, n)
+
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	db.insert(key, value, append(children, values...))
+	return nil
+}
+
+// PutValue stores a value referenced by hash, whatever its bytes are it has
+// no children
+func (db *NodeDatabase) PutValue(key, value []byte) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	db.insert(key, value, nil)
+	return nil
+}
+
+func (db *NodeDatabase) insert(key, blob []byte, children [][]byte) {
+	if _, ok := db.nodes[string(key)]; ok {
+		return
+	}
+
+	node := &cachedNode{blob: copyBytes(blob), children: children}
+	for _, child := range node.children {
+		if c, ok := db.nodes[string(child)]; ok {
+			c.parents++
+		}
+	}
+
+	node.elem = db.flushList.PushBack(string(key))
+	db.nodes[string(key)] = node
+	db.size += len(key) + len(blob)
+}
+
+func (db *NodeDatabase) Delete([]byte) error {
+	return ErrDeleteNotSupported
+}
+
+// Get returns the node stored under key, from memory or from disk
+func (db *NodeDatabase) Get(key []byte) ([]byte, error) {
+	db.lock.RLock()
+	node, ok := db.nodes[string(key)]
+	db.lock.RUnlock()
+
+	if ok {
+		return copyBytes(node.blob), nil
+	}
+
+	return db.disk.Get(key)
+}
+
+// Reference retains the trie with the given root, nodes already flushed are
+// not tracked anymore
+func (db *NodeDatabase) Reference(root []byte) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if node, ok := db.nodes[string(root)]; ok {
+		node.parents++
+	}
+}
+
+// Dereference releases a reference to root, when no reference is left the
+// root is removed and so is every child that no other node references.
+func (db *NodeDatabase) Dereference(root []byte) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	pending := [][]byte{root}
+	for len(pending) > 0 {
+		key := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		node, ok := db.nodes[string(key)]
+		if !ok {
+			continue
+		}
+
+		if node.parents > 0 {
+			node.parents--
+		}
+
+		if node.parents > 0 {
+			continue
+		}
+
+		db.flushList.Remove(node.elem)
+		delete(db.nodes, string(key))
+		db.size -= len(key) + len(node.blob)
+
+		pending = append(pending, node.children...)
+	}
+}
+
+// Cap flushes the oldest nodes to disk until the nodes in memory take at
+// most limit bytes. The flushed nodes are written in a single batch when disk
+// supports them, children always before their parents.
+func (db *NodeDatabase) Cap(limit int) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	var flushed []*list.Element
+	size := db.size
+
+	err := writeBatch(db.disk, func(w KVWriter) error {
+		for elem := db.flushList.Front(); elem != nil && size > limit; elem = elem.Next() {
+			key := elem.Value.(string)
+			node := db.nodes[key]
+
+			if err := w.Put([]byte(key), node.blob); err != nil {
+				return err
+			}
+
+			flushed = append(flushed, elem)
+			size -= len(key) + len(node.blob)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	for _, elem := range flushed {
+		delete(db.nodes, elem.Value.(string))
+		db.flushList.Remove(elem)
+	}
+
+	db.size = size
+	return nil
+}
+
+// Size returns the bytes taken by the keys and the nodes kept in memory
+func (db *NodeDatabase) Size() int {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	return db.size
+}
@@ -0,0 +1,137 @@
+package mptrie
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNodeDatabase_DereferenceShouldFreeUnusedNodes(t *testing.T) {
+	disk := NewInMemoryStorage()
+	db := NewNodeDatabase(disk)
+
+	trie, roots := commitVersions(t, db, 3)
+	for _, root := range roots {
+		db.Reference(root)
+	}
+
+	size := db.Size()
+	db.Dereference(roots[0])
+	require.Less(t, db.Size(), size)
+
+	_, err := db.Get(roots[0])
+	require.ErrorIs(t, err, KeyNotFound)
+
+	// the nodes shared with the newer versions are kept
+	requireTrieContent(t, trie, roots[2], db)
+	_, ok, err := OpenTrie(roots[1], db).TryGet([]byte("account-001"))
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	db.Dereference(roots[1])
+	db.Dereference(roots[2])
+	require.Zero(t, db.Size())
+
+	// nothing was written to disk
+	it := disk.NewIterator(nil, nil)
+	require.False(t, it.Next())
+}
+
+func TestNodeDatabase_ReferenceShouldKeepRootsCommittedTwice(t *testing.T) {
+	db := NewNodeDatabase(NewInMemoryStorage())
+
+	trie := buildTrie(t, namespacedKeys)
+	root, err := trie.Commit(db)
+	require.NoError(t, err)
+
+	_, err = trie.Copy().Commit(db)
+	require.NoError(t, err)
+
+	db.Reference(root)
+	db.Reference(root)
+
+	db.Dereference(root)
+	require.Equal(t, root, OpenTrie(root, db).Hash())
+	_, ok, err := OpenTrie(root, db).TryGet([]byte(namespacedKeys[0]))
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	db.Dereference(root)
+	require.Zero(t, db.Size())
+}
+
+func TestNodeDatabase_CapShouldFlushOldestNodes(t *testing.T) {
+	disk := NewInMemoryStorage()
+	db := NewNodeDatabase(disk, WithHashedValues(20, nil))
+
+	big := bytes.Repeat([]byte{0x07}, 40)
+	trie := NewTrie(WithHashedValues(20, nil))
+	require.NoError(t, trie.Put([]byte("big"), big))
+
+	_, roots := commitVersions(t, db, 2)
+	first, err := trie.Commit(db)
+	require.NoError(t, err)
+
+	for _, root := range append(roots, first) {
+		db.Reference(root)
+	}
+
+	size := db.Size()
+	require.NoError(t, db.Cap(size/2))
+	require.LessOrEqual(t, db.Size(), size/2)
+	require.Greater(t, db.Size(), 0)
+
+	// the oldest version is entirely on disk, the newest still needs memory
+	_, ok, err := OpenTrie(roots[0], disk).TryGet([]byte("account-000"))
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	_, _, err = OpenTrie(first, disk, WithHashedValues(20, nil)).TryGet([]byte("big"))
+	require.ErrorIs(t, err, KeyNotFound)
+
+	v, ok, err := OpenTrie(first, db, WithHashedValues(20, nil)).TryGet([]byte("big"))
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, big, v)
+
+	require.NoError(t, db.Cap(0))
+	require.Zero(t, db.Size())
+
+	v, ok, err = OpenTrie(first, disk, WithHashedValues(20, nil)).TryGet([]byte("big"))
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, big, v)
+
+	// releasing flushed roots does not touch the disk
+	db.Dereference(roots[0])
+	_, err = disk.Get(roots[0])
+	require.NoError(t, err)
+}
+
+func TestNodeDatabase_ShouldNotReferenceFromValues(t *testing.T) {
+	db := NewNodeDatabase(NewInMemoryStorage(), WithHashedValues(20, nil))
+
+	_, roots := commitVersions(t, db, 1)
+	db.Reference(roots[0])
+
+	// a value that is also a valid node referencing the committed root
+	value := RLPCodec.Encode(&ExtensionNode{Path: []Nibble{1}, Next: HashNode(roots[0])}, NewTrie().encoder().reference, nil)
+	_, err := RLPCodec.Decode(value)
+	require.NoError(t, err)
+
+	trie := NewTrie(WithHashedValues(20, nil))
+	require.NoError(t, trie.Put([]byte("node-like"), value))
+	root, err := trie.Commit(db)
+	require.NoError(t, err)
+	db.Reference(root)
+
+	db.Dereference(roots[0])
+	_, err = db.Get(roots[0])
+	require.ErrorIs(t, err, KeyNotFound)
+
+	v, ok, err := OpenTrie(root, db, WithHashedValues(20, nil)).TryGet([]byte("node-like"))
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, value, v)
+}
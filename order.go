@@ -0,0 +1,223 @@
+package mptrie
+
+// The trie keeps its keys sorted by nibble path, the methods below use that to
+// navigate it as an ordered map. Each of them walks a single path from the
+// root, picking the lowest or highest non empty slot of every branch node.
+
+// First returns the smallest key in the trie
+func (t Trie) First() (key, value []byte, ok bool) {
+	return toKey(dropErr(t.first(t.root, nil)))
+}
+
+// Last returns the greatest key in the trie
+func (t Trie) Last() (key, value []byte, ok bool) {
+	return toKey(dropErr(t.last(t.root, nil)))
+}
+
+// Ceiling returns the smallest key greater than or equal to key
+func (t Trie) Ceiling(key []byte) ([]byte, []byte, bool) {
+	return toKey(dropErr(t.ceiling(t.root, nil, FromBytes(key), false)))
+}
+
+// Floor returns the greatest key less than or equal to key
+func (t Trie) Floor(key []byte) ([]byte, []byte, bool) {
+	return toKey(dropErr(t.floor(t.root, nil, FromBytes(key), false)))
+}
+
+// Successor returns the smallest key strictly greater than key
+func (t Trie) Successor(key []byte) ([]byte, []byte, bool) {
+	return toKey(dropErr(t.ceiling(t.root, nil, FromBytes(key), true)))
+}
+
+// Predecessor returns the greatest key strictly less than key
+func (t Trie) Predecessor(key []byte) ([]byte, []byte, bool) {
+	return toKey(dropErr(t.floor(t.root, nil, FromBytes(key), true)))
+}
+
+func toKey(path []Nibble, value []byte, ok bool) ([]byte, []byte, bool) {
+	if !ok {
+		return nil, nil, false
+	}
+
+	return ToBytes(path), value, true
+}
+
+// dropErr reports a lookup that failed to load a node as a missing key
+func dropErr(path []Nibble, value []byte, ok bool, err error) ([]Nibble, []byte, bool) {
+	if err != nil {
+		return nil, nil, false
+	}
+
+	return path, value, ok
+}
+
+func (t Trie) first(n Node, path []Nibble) ([]Nibble, []byte, bool, error) {
+	n, err := t.resolve(n)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	switch node := n.(type) {
+	case *LeafNode:
+		return ConcatNibbles(path, node.Path), node.Value, true, nil
+	case *BranchNode:
+		if node.HasValue() {
+			return path, node.Value, true, nil
+		}
+
+		for i := 0; i < 16; i++ {
+			if node.Branches[i] != nil {
+				return t.first(node.Branches[i], ConcatNibbles(path, []Nibble{Nibble(i)}))
+			}
+		}
+	case *ExtensionNode:
+		return t.first(node.Next, ConcatNibbles(path, node.Path))
+	}
+
+	return nil, nil, false, nil
+}
+
+func (t Trie) last(n Node, path []Nibble) ([]Nibble, []byte, bool, error) {
+	n, err := t.resolve(n)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	switch node := n.(type) {
+	case *LeafNode:
+		return ConcatNibbles(path, node.Path), node.Value, true, nil
+	case *BranchNode:
+		for i := 15; i >= 0; i-- {
+			if node.Branches[i] != nil {
+				return t.last(node.Branches[i], ConcatNibbles(path, []Nibble{Nibble(i)}))
+			}
+		}
+
+		if node.HasValue() {
+			return path, node.Value, true, nil
+		}
+	case *ExtensionNode:
+		return t.last(node.Next, ConcatNibbles(path, node.Path))
+	}
+
+	return nil, nil, false, nil
+}
+
+// ceiling looks for the smallest key in n that is greater than target (or
+// equal to it when strict is false), every key below n starts with path.
+func (t Trie) ceiling(n Node, path, target []Nibble, strict bool) ([]Nibble, []byte, bool, error) {
+	if n == nil {
+		return nil, nil, false, nil
+	}
+
+	// when target does not continue through this node either all of its keys
+	// are greater than target or all of them are smaller
+	if !HasNibblePrefix(target, path) {
+		if CompareNibbles(path, target) > 0 {
+			return t.first(n, path)
+		}
+
+		return nil, nil, false, nil
+	}
+
+	n, err := t.resolve(n)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	switch node := n.(type) {
+	case *LeafNode:
+		key := ConcatNibbles(path, node.Path)
+		cmp := CompareNibbles(key, target)
+
+		if cmp > 0 || (cmp == 0 && !strict) {
+			return key, node.Value, true, nil
+		}
+	case *BranchNode:
+		from := 0
+
+		if len(target) == len(path) {
+			if node.HasValue() && !strict {
+				return path, node.Value, true, nil
+			}
+		} else {
+			nb := target[len(path)]
+			key, value, ok, err := t.ceiling(node.Branches[nb], ConcatNibbles(path, []Nibble{nb}), target, strict)
+			if ok || err != nil {
+				return key, value, ok, err
+			}
+
+			from = int(nb) + 1
+		}
+
+		for i := from; i < 16; i++ {
+			if node.Branches[i] != nil {
+				return t.first(node.Branches[i], ConcatNibbles(path, []Nibble{Nibble(i)}))
+			}
+		}
+	case *ExtensionNode:
+		return t.ceiling(node.Next, ConcatNibbles(path, node.Path), target, strict)
+	}
+
+	return nil, nil, false, nil
+}
+
+// floor looks for the greatest key in n that is less than target (or equal
+// to it when strict is false), every key below n starts with path.
+func (t Trie) floor(n Node, path, target []Nibble, strict bool) ([]Nibble, []byte, bool, error) {
+	if n == nil {
+		return nil, nil, false, nil
+	}
+
+	if !HasNibblePrefix(target, path) {
+		if CompareNibbles(path, target) < 0 {
+			return t.last(n, path)
+		}
+
+		return nil, nil, false, nil
+	}
+
+	n, err := t.resolve(n)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	switch node := n.(type) {
+	case *LeafNode:
+		key := ConcatNibbles(path, node.Path)
+		cmp := CompareNibbles(key, target)
+
+		if cmp < 0 || (cmp == 0 && !strict) {
+			return key, node.Value, true, nil
+		}
+	case *BranchNode:
+		// every key stored in the branches is greater than the branch value
+		if len(target) == len(path) {
+			if node.HasValue() && !strict {
+				return path, node.Value, true, nil
+			}
+
+			return nil, nil, false, nil
+		}
+
+		nb := target[len(path)]
+		key, value, ok, err := t.floor(node.Branches[nb], ConcatNibbles(path, []Nibble{nb}), target, strict)
+		if ok || err != nil {
+			return key, value, ok, err
+		}
+
+		for i := int(nb) - 1; i >= 0; i-- {
+			if node.Branches[i] != nil {
+				return t.last(node.Branches[i], ConcatNibbles(path, []Nibble{Nibble(i)}))
+			}
+		}
+
+		if node.HasValue() {
+			return path, node.Value, true, nil
+		}
+	case *ExtensionNode:
+		return t.floor(node.Next, ConcatNibbles(path, node.Path), target, strict)
+	}
+
+	return nil, nil, false, nil
+}
@@ -0,0 +1,112 @@
+package mptrie
+
+import (
+	"bytes"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func sortedKeys(keys []string) [][]byte {
+	sorted := make([][]byte, 0, len(keys))
+	for _, k := range keys {
+		sorted = append(sorted, []byte(k))
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i], sorted[j]) < 0
+	})
+
+	return sorted
+}
+
+func TestFirstAndLast(t *testing.T) {
+	trie := NewTrie()
+
+	_, _, ok := trie.First()
+	require.False(t, ok)
+
+	_, _, ok = trie.Last()
+	require.False(t, ok)
+
+	trie = buildTrie(t, namespacedKeys)
+
+	key, value, ok := trie.First()
+	require.True(t, ok)
+	require.Equal(t, []byte("accounts"), key)
+	require.Equal(t, []byte("value of accounts"), value)
+
+	key, value, ok = trie.Last()
+	require.True(t, ok)
+	require.Equal(t, []byte("transfer.to"), key)
+	require.Equal(t, []byte("value of transfer.to"), value)
+}
+
+func TestNavigation_ShouldMatchSortedKeys(t *testing.T) {
+	trie := buildTrie(t, namespacedKeys)
+	sorted := sortedKeys(namespacedKeys)
+
+	queries := append(sortedKeys(namespacedKeys),
+		[]byte("a"), []byte("accounts.c"), []byte("accounts.zzz"),
+		[]byte("block"), []byte("c"), []byte("transfer.input.v"),
+		[]byte("transfer.inputs"), []byte("zzz"), []byte{0x00},
+	)
+
+	for _, q := range queries {
+		var ceil, succ, fl, pred []byte
+		for _, k := range sorted {
+			cmp := bytes.Compare(k, q)
+			if ceil == nil && cmp >= 0 {
+				ceil = k
+			}
+			if succ == nil && cmp > 0 {
+				succ = k
+			}
+			if cmp <= 0 {
+				fl = k
+			}
+			if cmp < 0 {
+				pred = k
+			}
+		}
+
+		checks := []struct {
+			name     string
+			expected []byte
+			query    func([]byte) ([]byte, []byte, bool)
+		}{
+			{"Ceiling", ceil, trie.Ceiling},
+			{"Successor", succ, trie.Successor},
+			{"Floor", fl, trie.Floor},
+			{"Predecessor", pred, trie.Predecessor},
+		}
+
+		for _, c := range checks {
+			key, value, ok := c.query(q)
+			if c.expected == nil {
+				require.False(t, ok, "%s(%q)", c.name, q)
+				continue
+			}
+
+			require.True(t, ok, "%s(%q)", c.name, q)
+			require.Equal(t, c.expected, key, "%s(%q)", c.name, q)
+			require.Equal(t, append([]byte("value of "), key...), value)
+		}
+	}
+}
+
+func TestSuccessor_ShouldWalkEveryKeyInOrder(t *testing.T) {
+	trie := buildTrie(t, namespacedKeys)
+	sorted := sortedKeys(namespacedKeys)
+
+	key, _, ok := trie.First()
+	walked := [][]byte{}
+
+	for ok {
+		walked = append(walked, key)
+		key, _, ok = trie.Successor(key)
+	}
+
+	require.Equal(t, sorted, walked)
+}
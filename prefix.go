@@ -0,0 +1,114 @@
+package mptrie
+
+import "bytes"
+
+// LongestPrefix returns the longest key stored in the trie that is a prefix of
+// key, including key itself. The values kept in the branch nodes and leaves
+// along the path of key are the only candidates, so a single walk is enough.
+func (t Trie) LongestPrefix(key []byte) (matchedKey, value []byte, ok bool) {
+	path, holder, ok, err := longestPrefix(t.root, FromBytes(key), t.resolve)
+	value, _ = storedValue(holder, t.encoder())
+	return toKey(dropErr(path, value, ok, err))
+}
+
+// CreateLongestPrefixProof writes into r every node walked to answer
+// LongestPrefix for key, they prove both the match and that no longer prefix
+// of key is stored in the trie. The matched value is also written when it is
+// referenced by hash.
+func CreateLongestPrefixProof(key []byte, t *Trie, r KVWriter) error {
+	var nodes []Node
+
+	collect := func(n Node) (Node, error) {
+		n, err := t.resolve(n)
+		if err != nil {
+			return nil, err
+		}
+
+		if n != nil {
+			nodes = append(nodes, n)
+		}
+
+		return n, nil
+	}
+
+	_, holder, _, err := longestPrefix(t.root, FromBytes(key), collect)
+	if err != nil {
+		return err
+	}
+
+	return writeProof(t.encoder(), nodes, holder, r)
+}
+
+// VerifyLongestPrefixProof answers LongestPrefix for key using only the proof
+// nodes created by CreateLongestPrefixProof, every node is checked against
+// the given root hash. The options must match the ones of the proved trie.
+func VerifyLongestPrefixProof(root, key []byte, r NodeReader, opts ...Option) (matchedKey, value []byte, ok bool, err error) {
+	e := NewTrie(opts...).encoder()
+	if bytes.Equal(root, e.emptyRoot()) {
+		return nil, nil, false, nil
+	}
+
+	path, holder, ok, err := longestPrefix(HashNode(root), FromBytes(key), proofResolver(r, e))
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	value, err = proofValue(holder, r, e)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	matchedKey, value, ok = toKey(path, value, ok)
+	return matchedKey, value, ok, nil
+}
+
+// longestPrefix returns the longest stored prefix of nibbles and the leaf or
+// branch node holding its value
+func longestPrefix(n Node, nibbles []Nibble, resolve resolver) ([]Nibble, Node, bool, error) {
+	var (
+		path, matched []Nibble
+		holder        Node
+		found         bool
+	)
+
+	for {
+		node, err := resolve(n)
+		if err != nil {
+			return nil, nil, false, err
+		}
+
+		if leaf, ok := node.(*LeafNode); ok {
+			if HasNibblePrefix(nibbles, leaf.Path) {
+				return ConcatNibbles(path, leaf.Path), leaf, true, nil
+			}
+
+			return matched, holder, found, nil
+		}
+
+		if branch, ok := node.(*BranchNode); ok {
+			if branch.HasValue() {
+				matched, holder, found = path, branch, true
+			}
+
+			if len(nibbles) == 0 {
+				return matched, holder, found, nil
+			}
+
+			path = ConcatNibbles(path, nibbles[:1])
+			n, nibbles = branch.Branches[nibbles[0]], nibbles[1:]
+			continue
+		}
+
+		if ext, ok := node.(*ExtensionNode); ok {
+			if !HasNibblePrefix(nibbles, ext.Path) {
+				return matched, holder, found, nil
+			}
+
+			path = ConcatNibbles(path, ext.Path)
+			n, nibbles = ext.Next, nibbles[len(ext.Path):]
+			continue
+		}
+
+		return matched, holder, found, nil
+	}
+}
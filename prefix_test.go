@@ -0,0 +1,96 @@
+package mptrie
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var routeKeys = []string{
+	"service",
+	"service/eu",
+	"service/eu/host-01",
+	"service/us/host-01",
+	"service/us/host-02",
+	"storage/eu",
+}
+
+func TestLongestPrefix(t *testing.T) {
+	trie := buildTrie(t, routeKeys)
+
+	cases := []struct {
+		query    string
+		expected string
+	}{
+		{"service/eu/host-01", "service/eu/host-01"},
+		{"service/eu/host-02", "service/eu"},
+		{"service/eu/host-01/disk", "service/eu/host-01"},
+		{"service/us/host-03", "service"},
+		{"service/asia", "service"},
+		{"storage/eu/host-01", "storage/eu"},
+		{"storage/us", ""},
+		{"serv", ""},
+		{"network", ""},
+	}
+
+	for _, c := range cases {
+		key, value, ok := trie.LongestPrefix([]byte(c.query))
+
+		if c.expected == "" {
+			require.False(t, ok, c.query)
+			continue
+		}
+
+		require.True(t, ok, c.query)
+		require.Equal(t, []byte(c.expected), key, c.query)
+		require.Equal(t, []byte("value of "+c.expected), value, c.query)
+	}
+}
+
+func TestLongestPrefixProof(t *testing.T) {
+	trie := buildTrie(t, routeKeys)
+	queries := []string{"service/eu/host-02", "service/us/host-01", "storage/us", "network", "service"}
+
+	for _, q := range queries {
+		proof := NewInMemoryStorage()
+		err := CreateLongestPrefixProof([]byte(q), trie, proof)
+		require.NoError(t, err)
+
+		expectedKey, expectedValue, expectedOk := trie.LongestPrefix([]byte(q))
+
+		key, value, ok, err := VerifyLongestPrefixProof(trie.Hash(), []byte(q), proof)
+		require.NoError(t, err)
+		require.Equal(t, expectedOk, ok, q)
+		require.Equal(t, expectedKey, key, q)
+		require.Equal(t, expectedValue, value, q)
+	}
+}
+
+func TestLongestPrefixProof_ShouldFailWithIncompleteProof(t *testing.T) {
+	trie := buildTrie(t, routeKeys)
+
+	proof := NewInMemoryStorage()
+	err := CreateLongestPrefixProof([]byte("service/us/host-02"), trie, proof)
+	require.NoError(t, err)
+
+	_, _, _, err = VerifyLongestPrefixProof(trie.Hash(), []byte("service/eu/host-01"), proof)
+	require.Error(t, err)
+}
+
+func TestLongestPrefixProof_ShouldFailWhenNodeIsTampered(t *testing.T) {
+	trie := buildTrie(t, routeKeys)
+
+	proof := NewInMemoryStorage()
+	err := CreateLongestPrefixProof([]byte("service/eu"), trie, proof)
+	require.NoError(t, err)
+
+	tampered := buildTrie(t, routeKeys)
+	err = tampered.Put([]byte("service/eu"), []byte("other value"))
+	require.NoError(t, err)
+
+	err = proof.Put(trie.Hash(), Serialize(tampered.root))
+	require.NoError(t, err)
+
+	_, _, _, err = VerifyLongestPrefixProof(trie.Hash(), []byte("service/eu"), proof)
+	require.ErrorIs(t, err, ErrWhileProof)
+}
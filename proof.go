@@ -1,6 +1,9 @@
 package mptrie
 
-import "errors"
+import (
+	"bytes"
+	"errors"
+)
 
 var (
 	ErrWhileProof = errors.New("problem while verify proof")
@@ -9,9 +12,17 @@ var (
 func CreateProof(key []byte, t *Trie, r KVWriter) error {
 	node := t.root
 	nibbles := FromBytes(key)
-	var nodes []Node
+	var (
+		nodes []Node
+		err   error
+	)
 
 	for {
+		node, err = t.resolve(node)
+		if err != nil {
+			return err
+		}
+
 		if node == nil {
 			return errors.New("node is empty")
 		}
@@ -30,6 +41,10 @@ func CreateProof(key []byte, t *Trie, r KVWriter) error {
 			nodes = append(nodes, branch)
 
 			if len(nibbles) == 0 {
+				if !branch.HasValue() {
+					return errors.New("key not found, cannot generate proof")
+				}
+
 				break
 			}
 
@@ -53,28 +68,102 @@ func CreateProof(key []byte, t *Trie, r KVWriter) error {
 		}
 	}
 
+	return writeProof(t.encoder(), nodes, nodes[len(nodes)-1], r)
+}
+
+// writeProof writes nodes into r keyed by their hash, along with the value of
+// holder when it is referenced by hash
+func writeProof(e encoder, nodes []Node, holder Node, r KVWriter) error {
 	for _, n := range nodes {
-		if err := r.Put(Hash(n), Serialize(n)); err != nil {
+		if err := r.Put(e.hash(n), e.serialize(n)); err != nil {
 			return err
 		}
 	}
 
+	if value, hash := storedValue(holder, e); hash != nil {
+		return r.Put(hash, value)
+	}
+
 	return nil
 }
 
-// func VerifyProof(root, key []byte, w KVReader) ([]byte, error) {
-// 	nibbles := FromBytes(key)
-// 	want := root
+// proofValue returns the value kept by holder, a node from a proof, reading
+// it from the proof when the node only has its hash
+func proofValue(holder Node, r NodeReader, e encoder) ([]byte, error) {
+	value, hash := storedValue(holder, e)
+	if value != nil || hash == nil {
+		return value, nil
+	}
+
+	return readValue(r, e, hash)
+}
+
+// proofResolver loads the nodes referenced by hash from a set of proof nodes,
+// checking that each of them really hashes to the expected value
+func proofResolver(r NodeReader, e encoder) resolver {
+	return func(n Node) (Node, error) {
+		hash, ok := n.(HashNode)
+		if !ok {
+			return n, nil
+		}
+
+		encoded, err := r.Get(hash)
+		if err != nil {
+			return nil, err
+		}
+
+		if !bytes.Equal(e.hasher.Hash(encoded), hash) {
+			return nil, ErrWhileProof
+		}
+
+		return e.decode(encoded)
+	}
+}
+
+// VerifyProof returns the value of key using only the proof nodes created by
+// CreateProof, every node is checked against the given root hash. A proof
+// that ends before reaching key proves it is not in the trie, nil is returned
+// then. The options must match the ones of the proved trie.
+func VerifyProof(root, key []byte, r NodeReader, opts ...Option) ([]byte, error) {
+	e := NewTrie(opts...).encoder()
+	if bytes.Equal(root, e.emptyRoot()) {
+		return nil, nil
+	}
+
+	resolve := proofResolver(r, e)
+	n, nibbles := Node(HashNode(root)), FromBytes(key)
+
+	for {
+		node, err := resolve(n)
+		if err != nil {
+			return nil, err
+		}
+
+		switch node := node.(type) {
+		case *LeafNode:
+			if len(node.Path) != len(nibbles) || !HasNibblePrefix(nibbles, node.Path) {
+				return nil, nil
+			}
 
-// 	for {
-// 		b, err := w.Get(want)
-// 		if err != nil {
-// 			return nil, err
-// 		}
+			return proofValue(node, r, e)
+		case *BranchNode:
+			if len(nibbles) == 0 {
+				if !node.HasValue() {
+					return nil, nil
+				}
 
-// 		if b == nil {
-// 			return nil, ErrWhileProof
-// 		}
+				return proofValue(node, r, e)
+			}
 
-// 	}
-// }
+			n, nibbles = node.Branches[nibbles[0]], nibbles[1:]
+		case *ExtensionNode:
+			if !HasNibblePrefix(nibbles, node.Path) {
+				return nil, nil
+			}
+
+			n, nibbles = node.Next, nibbles[len(node.Path):]
+		default:
+			return nil, nil
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
 	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/stretchr/testify/require"
 
@@ -76,3 +77,76 @@ func TestCreateProof(t *testing.T) {
 
 	fmt.Println(v, string(p.ProposersSig[0]))
 }
+
+func TestCreateProof_ShouldRejectKeysEndingAtBranchesWithoutValue(t *testing.T) {
+	trie := NewTrie()
+	require.NoError(t, trie.Put([]byte{0x12, 0x34}, []byte("a")))
+	require.NoError(t, trie.Put([]byte{0x12, 0x56}, []byte("b")))
+
+	// 0x12 ends at the branch holding both keys, which has no value
+	err := CreateProof([]byte{0x12}, trie, NewInMemoryStorage())
+	require.Error(t, err)
+
+	require.NoError(t, trie.Put([]byte{0x12}, []byte("c")))
+
+	proof := NewInMemoryStorage()
+	require.NoError(t, CreateProof([]byte{0x12}, trie, proof))
+
+	v, err := ethtrie.VerifyProof(common.BytesToHash(trie.Hash()), []byte{0x12}, proof)
+	require.NoError(t, err)
+	require.Equal(t, []byte("c"), v)
+}
+
+func TestVerifyProof_ShouldReturnTheProvedValue(t *testing.T) {
+	trie := buildTrie(t, namespacedKeys)
+	root := trie.Hash()
+
+	for _, k := range namespacedKeys {
+		proof := NewInMemoryStorage()
+		require.NoError(t, CreateProof([]byte(k), trie, proof))
+
+		v, err := VerifyProof(root, []byte(k), proof)
+		require.NoError(t, err)
+		require.Equal(t, []byte("value of "+k), v)
+	}
+
+	// geth proves absent keys with the nodes along their path
+	eth, err := ethtrie.New(common.Hash{}, ethtrie.NewDatabase(memorydb.New()))
+	require.NoError(t, err)
+	for _, k := range namespacedKeys {
+		eth.Update([]byte(k), []byte("value of "+k))
+	}
+
+	proof := NewInMemoryStorage()
+	require.NoError(t, eth.Prove([]byte("accounts.unknown"), 0, proof))
+
+	v, err := VerifyProof(root, []byte("accounts.unknown"), proof)
+	require.NoError(t, err)
+	require.Nil(t, v)
+
+	// a node that does not match its hash
+	proof = NewInMemoryStorage()
+	require.NoError(t, CreateProof([]byte("accounts"), trie, proof))
+	require.NoError(t, proof.Put(root, []byte{0xc2, 0x80, 0x80}))
+
+	_, err = VerifyProof(root, []byte("accounts"), proof)
+	require.ErrorIs(t, err, ErrWhileProof)
+
+	v, err = VerifyProof(NewTrie().Hash(), []byte("accounts"), NewInMemoryStorage())
+	require.NoError(t, err)
+	require.Nil(t, v)
+}
+
+func TestVerifyProof_ShouldReadHashedValues(t *testing.T) {
+	big := make([]byte, 100)
+	trie := NewTrie(WithHashedValues(33, nil))
+	require.NoError(t, trie.Put([]byte("big"), big))
+	require.NoError(t, trie.Put([]byte("bit"), []byte("small")))
+
+	proof := NewInMemoryStorage()
+	require.NoError(t, CreateProof([]byte("big"), trie, proof))
+
+	v, err := VerifyProof(trie.Hash(), []byte("big"), proof, WithHashedValues(33, nil))
+	require.NoError(t, err)
+	require.Equal(t, big, v)
+}
@@ -0,0 +1,498 @@
+package mptrie
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+)
+
+var (
+	ErrStaleRoot   = errors.New("root is not the latest committed one")
+	ErrUnknownRoot = errors.New("root is neither the latest one nor in a diff layer")
+	ErrStaleNode   = errors.New("node changed since the trie was opened")
+)
+
+// NodeScheme is the layout of the committed nodes in a Database, tries are
+// opened and committed the same way whatever the layout is.
+type NodeScheme interface {
+	Open(root []byte) (*Trie, error)
+	Commit(t *Trie) ([]byte, error)
+}
+
+var _ NodeScheme = (*HashScheme)(nil)
+var _ NodeScheme = (*PathScheme)(nil)
+
+// HashScheme stores every node keyed by its hash, so any root committed can be
+// opened but replaced nodes are left behind until they are pruned.
+type HashScheme struct {
+	db   Database
+	opts []Option
+}
+
+// NewHashScheme returns the hash layout over db, opts must match the ones of
+// the tries committed with it
+func NewHashScheme(db Database, opts ...Option) *HashScheme {
+	return &HashScheme{db: db, opts: opts}
+}
+
+func (s *HashScheme) Open(root []byte) (*Trie, error) {
+	return OpenTrie(root, s.db, s.opts...), nil
+}
+
+func (s *HashScheme) Commit(t *Trie) ([]byte, error) {
+	return t.Commit(s.db)
+}
+
+const (
+	pathNodePrefix  = 'p'
+	pathValuePrefix = 'v'
+)
+
+// PathScheme stores every node under its owner and its nibble path, so each
+// commit overwrites the nodes of the previous one in place and only the
+// latest root can be opened. The writes of the latest commits are kept in
+// memory as reverse diff layers, Rollback undoes them to go back to one of
+// their roots. Owners keep several tries in the same Database, each of them
+// must have a single PathScheme. Values referenced by hash are stored keyed
+// by their hash and are never removed.
+type PathScheme struct {
+	db     Database
+	owner  []byte
+	limit  int
+	opts   []Option
+	e      encoder
+	lock   sync.Mutex
+	layers []pathLayer
+}
+
+// pathLayer holds what a commit overwrote, blob is nil for the keys that did
+// not exist before it
+type pathLayer struct {
+	root    []byte
+	changes []pathChange
+}
+
+type pathChange struct {
+	key, blob []byte
+}
+
+// NewPathScheme returns the path layout of the trie of owner in db keeping
+// up to layers reverse diffs, opts must match the ones of the tries committed
+// with it
+func NewPathScheme(db Database, owner []byte, layers int, opts ...Option) *PathScheme {
+	return &PathScheme{
+		db:    db,
+		owner: copyBytes(owner),
+		limit: layers,
+		opts:  opts,
+		e:     NewTrie(opts...).encoder(),
+	}
+}
+
+// nodeKey is the key of the node of owner at path, the owner length keeps
+// the keys of different owners apart
+func nodeKey(owner []byte, path []Nibble) []byte {
+	key := make([]byte, 0, 2+len(owner)+len(path))
+	key = append(key, pathNodePrefix, byte(len(owner)))
+	key = append(key, owner...)
+
+	for _, n := range path {
+		key = append(key, byte(n))
+	}
+
+	return key
+}
+
+func valueKey(hash []byte) []byte {
+	return append([]byte{pathValuePrefix}, hash...)
+}
+
+// Root returns the latest committed root
+func (s *PathScheme) Root() ([]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.root()
+}
+
+func (s *PathScheme) root() ([]byte, error) {
+	blob, err := s.get(nodeKey(s.owner, nil))
+	if err != nil {
+		return nil, err
+	}
+
+	if blob == nil {
+		return s.e.emptyRoot(), nil
+	}
+
+	return s.e.hasher.Hash(blob), nil
+}
+
+// get returns the blob under key or nil when there is none
+func (s *PathScheme) get(key []byte) ([]byte, error) {
+	blob, err := s.db.Get(key)
+	if errors.Is(err, KeyNotFound) {
+		return nil, nil
+	}
+
+	return blob, err
+}
+
+// Open returns the trie with the latest root, older roots must be brought
+// back with Rollback first
+func (s *PathScheme) Open(root []byte) (*Trie, error) {
+	latest, err := s.Root()
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(root, latest) {
+		return nil, ErrStaleRoot
+	}
+
+	// the reader learns the path of each node as it loads them, so nodes
+	// are not taken from a cache
+	r := &pathReader{scheme: s, root: copyBytes(root), paths: map[string][]Nibble{string(root): nil}}
+	t := OpenTrie(root, r, s.opts...)
+	t.cache = nil
+
+	return t, nil
+}
+
+// Commit stores the nodes of t that changed since the latest root and
+// removes the ones that are not part of the trie anymore, in a single batch.
+// t must have been opened with Open or built in memory, a trie opened at a
+// root that is not the latest one anymore returns ErrStaleRoot.
+func (s *PathScheme) Commit(t *Trie) ([]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	old, err := s.root()
+	if err != nil {
+		return nil, err
+	}
+
+	// the nodes t did not change are the ones stored when it was opened
+	r, opened := t.db.(*pathReader)
+	if opened && r.scheme == s && !bytes.Equal(r.root, old) {
+		return nil, ErrStaleRoot
+	}
+
+	nodes := make(nodeSet)
+	root, err := t.Commit(nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.Equal(root, old) {
+		return root, nil
+	}
+
+	puts, kept, values := s.newNodes(root, nodes)
+	deletes, err := s.oldNodes(old, puts, kept)
+	if err != nil {
+		return nil, err
+	}
+
+	layer := pathLayer{root: old}
+	for _, path := range append(deletes, sortedMapKeys(puts)...) {
+		key := nodeKey(s.owner, []Nibble(path))
+		blob, err := s.get(key)
+		if err != nil {
+			return nil, err
+		}
+
+		layer.changes = append(layer.changes, pathChange{key: key, blob: blob})
+	}
+
+	err = writeBatch(s.db, func(w KVWriter) error {
+		for _, path := range deletes {
+			if err := w.Delete(nodeKey(s.owner, []Nibble(path))); err != nil {
+				return err
+			}
+		}
+
+		for path, blob := range puts {
+			if err := w.Put(nodeKey(s.owner, []Nibble(path)), blob); err != nil {
+				return err
+			}
+		}
+
+		for hash, value := range values {
+			if err := w.Put(valueKey([]byte(hash)), value); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	s.layers = append(s.layers, layer)
+	if len(s.layers) > s.limit {
+		s.layers = s.layers[len(s.layers)-s.limit:]
+	}
+
+	// t is now at the latest root and can be committed again, its copies
+	// keep the reader of the root they were opened at
+	if opened && r.scheme == s {
+		t.db = r.at(root)
+	}
+
+	return root, nil
+}
+
+// newNodes places the committed nodes under their paths starting from root.
+// Children that were not committed did not change, they are kept where they
+// are since a node that does not change has the same keys below it and so
+// the same path. Committed entries that are not nodes of the trie are values
+// referenced by hash.
+func (s *PathScheme) newNodes(root []byte, nodes nodeSet) (puts map[string][]byte, kept map[string][]byte, values nodeSet) {
+	puts, kept = make(map[string][]byte), make(map[string][]byte)
+
+	type entry struct {
+		path []Nibble
+		hash []byte
+	}
+
+	// identical nodes can be placed under several paths
+	placed := make(map[string]bool)
+
+	var pending []entry
+	if !bytes.Equal(root, s.e.emptyRoot()) {
+		pending = append(pending, entry{hash: root})
+	}
+
+	for len(pending) > 0 {
+		next := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		blob, ok := nodes[string(next.hash)]
+		if !ok {
+			kept[string(next.path)] = next.hash
+			continue
+		}
+
+		puts[string(next.path)] = blob
+		placed[string(next.hash)] = true
+
+		n, err := s.e.decode(blob)
+		if err != nil {
+			continue
+		}
+
+		hashedChildren(n, func(rel []Nibble, hash []byte) {
+			pending = append(pending, entry{path: ConcatNibbles(next.path, rel), hash: hash})
+		})
+	}
+
+	values = make(nodeSet)
+	for hash, blob := range nodes {
+		if !placed[hash] {
+			values[hash] = blob
+		}
+	}
+
+	return puts, kept, values
+}
+
+// oldNodes walks the nodes stored under the latest root that are not kept
+// and returns the paths that the new nodes do not overwrite
+func (s *PathScheme) oldNodes(root []byte, puts, kept map[string][]byte) ([]string, error) {
+	if bytes.Equal(root, s.e.emptyRoot()) {
+		return nil, nil
+	}
+
+	var deletes []string
+	pending := [][]Nibble{nil}
+
+	for len(pending) > 0 {
+		path := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		blob, err := s.get(nodeKey(s.owner, path))
+		if err != nil {
+			return nil, err
+		}
+
+		if blob == nil {
+			continue
+		}
+
+		if _, ok := puts[string(path)]; !ok {
+			deletes = append(deletes, string(path))
+		}
+
+		n, err := s.e.decode(blob)
+		if err != nil {
+			return nil, err
+		}
+
+		hashedChildren(n, func(rel []Nibble, hash []byte) {
+			child := ConcatNibbles(path, rel)
+			if !bytes.Equal(kept[string(child)], hash) {
+				pending = append(pending, child)
+			}
+		})
+	}
+
+	return deletes, nil
+}
+
+// Rollback undoes the latest commits until root is the latest root again,
+// root must be the latest one or the root before one of the diff layers.
+func (s *PathScheme) Rollback(root []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	latest, err := s.root()
+	if err != nil {
+		return err
+	}
+
+	if bytes.Equal(root, latest) {
+		return nil
+	}
+
+	from := -1
+	for i := len(s.layers) - 1; i >= 0; i-- {
+		if bytes.Equal(s.layers[i].root, root) {
+			from = i
+			break
+		}
+	}
+
+	if from < 0 {
+		return ErrUnknownRoot
+	}
+
+	err = writeBatch(s.db, func(w KVWriter) error {
+		for i := len(s.layers) - 1; i >= from; i-- {
+			for _, change := range s.layers[i].changes {
+				if change.blob == nil {
+					err = w.Delete(change.key)
+				} else {
+					err = w.Put(change.key, change.blob)
+				}
+
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	s.layers = s.layers[:from]
+	return nil
+}
+
+// pathReader loads the nodes of a trie opened from a PathScheme, it learns
+// the path of each child from the nodes loaded before it.
+type pathReader struct {
+	scheme *PathScheme
+	// root is the root the trie was opened at
+	root  []byte
+	lock  sync.Mutex
+	paths map[string][]Nibble
+}
+
+// at returns a reader of the same nodes for a trie now at root, the nodes
+// left unchanged keep their paths
+func (r *pathReader) at(root []byte) *pathReader {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	paths := make(map[string][]Nibble, len(r.paths))
+	for hash, path := range r.paths {
+		paths[hash] = path
+	}
+
+	return &pathReader{scheme: r.scheme, root: copyBytes(root), paths: paths}
+}
+
+func (r *pathReader) Get(hash []byte) ([]byte, error) {
+	r.lock.Lock()
+	path, ok := r.paths[string(hash)]
+	r.lock.Unlock()
+
+	s := r.scheme
+	if !ok {
+		return s.db.Get(valueKey(hash))
+	}
+
+	blob, err := s.db.Get(nodeKey(s.owner, path))
+	if errors.Is(err, KeyNotFound) {
+		return nil, ErrStaleNode
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(s.e.hasher.Hash(blob), hash) {
+		return nil, ErrStaleNode
+	}
+
+	n, err := s.e.decode(blob)
+	if err != nil {
+		return nil, err
+	}
+
+	r.lock.Lock()
+	hashedChildren(n, func(rel []Nibble, child []byte) {
+		r.paths[string(child)] = ConcatNibbles(path, rel)
+	})
+	r.lock.Unlock()
+
+	return blob, nil
+}
+
+// hashedChildren calls fn with every child of n referenced by hash and its
+// path relative to n, looking into the embedded children as well
+func hashedChildren(n Node, fn func(rel []Nibble, hash []byte)) {
+	type entry struct {
+		node Node
+		path []Nibble
+	}
+
+	pending := []entry{{node: n}}
+	for len(pending) > 0 {
+		next := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		switch node := next.node.(type) {
+		case HashNode:
+			fn(next.path, node)
+		case *BranchNode:
+			for i, child := range node.Branches {
+				if child != nil {
+					pending = append(pending, entry{node: child, path: ConcatNibbles(next.path, []Nibble{Nibble(i)})})
+				}
+			}
+		case *ExtensionNode:
+			pending = append(pending, entry{node: node.Next, path: ConcatNibbles(next.path, node.Path)})
+		}
+	}
+}
+
+// nodeSet collects the nodes of a commit keyed by their hash
+type nodeSet map[string][]byte
+
+func (s nodeSet) Put(key, value []byte) error {
+	s[string(key)] = copyBytes(value)
+	return nil
+}
+
+func (s nodeSet) Delete(key []byte) error {
+	delete(s, string(key))
+	return nil
+}
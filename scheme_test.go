@@ -0,0 +1,229 @@
+package mptrie
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// schemeVersions commits a version of the trie per round through s, every
+// round updates some keys, deletes others and adds new ones
+func schemeVersions(t *testing.T, s NodeScheme, rounds int) ([]*Trie, [][]byte) {
+	trie := NewTrie()
+	var tries []*Trie
+	var roots [][]byte
+
+	for round := 0; round < rounds; round++ {
+		for i := round; i < 200+round*10; i += round%3 + 1 {
+			key := []byte(fmt.Sprintf("key-%03d", i))
+			require.NoError(t, trie.Put(key, []byte(fmt.Sprintf("value %d at round %d", i, round))))
+		}
+
+		for i := round; i < 200; i += 7 {
+			require.NoError(t, trie.Delete([]byte(fmt.Sprintf("key-%03d", i))))
+		}
+
+		root, err := s.Commit(trie)
+		require.NoError(t, err)
+
+		tries = append(tries, trie.Copy())
+		roots = append(roots, root)
+
+		trie, err = s.Open(root)
+		require.NoError(t, err)
+	}
+
+	return tries, roots
+}
+
+// storedNodes returns the nodes stored by a PathScheme keyed by their path
+func storedNodes(t *testing.T, db Database) map[string]string {
+	it := db.NewIterator([]byte{pathNodePrefix}, nil)
+	defer it.Release()
+
+	nodes := make(map[string]string)
+	for it.Next() {
+		nodes[string(it.Key())] = string(it.Value())
+	}
+
+	require.NoError(t, it.Error())
+	return nodes
+}
+
+func requireSameKeys(t *testing.T, expected *Trie, opened *Trie) {
+	for i := 0; i < 300; i++ {
+		key := []byte(fmt.Sprintf("key-%03d", i))
+		want, wantOK := expected.Get(key)
+
+		got, ok, err := opened.TryGet(key)
+		require.NoError(t, err)
+		require.Equal(t, wantOK, ok, string(key))
+		require.Equal(t, want, got, string(key))
+	}
+}
+
+func TestNodeScheme_ShouldOpenCommittedTries(t *testing.T) {
+	schemes := map[string]NodeScheme{
+		"hash": NewHashScheme(NewInMemoryStorage()),
+		"path": NewPathScheme(NewInMemoryStorage(), nil, 4),
+	}
+
+	for name, s := range schemes {
+		t.Run(name, func(t *testing.T) {
+			tries, roots := schemeVersions(t, s, 5)
+			latest := roots[len(roots)-1]
+
+			opened, err := s.Open(latest)
+			require.NoError(t, err)
+			require.Equal(t, latest, opened.Hash())
+			requireSameKeys(t, tries[len(tries)-1], opened)
+		})
+	}
+}
+
+func TestPathScheme_ShouldOverwriteNodesInPlace(t *testing.T) {
+	db := NewInMemoryStorage()
+	tries, roots := schemeVersions(t, NewPathScheme(db, nil, 4), 6)
+
+	// the same trie committed at once is stored under the same keys
+	fresh := NewInMemoryStorage()
+	root, err := NewPathScheme(fresh, nil, 4).Commit(tries[len(tries)-1])
+	require.NoError(t, err)
+	require.Equal(t, roots[len(roots)-1], root)
+
+	require.Equal(t, storedNodes(t, fresh), storedNodes(t, db))
+
+	// deleting every key removes every node
+	s := NewPathScheme(db, nil, 4)
+	empty := NewTrie()
+	root, err = s.Commit(empty)
+	require.NoError(t, err)
+	require.Equal(t, empty.Hash(), root)
+	require.Empty(t, storedNodes(t, db))
+}
+
+func TestPathScheme_ShouldRollbackToRecentRoots(t *testing.T) {
+	db := NewInMemoryStorage()
+	s := NewPathScheme(db, nil, 3)
+	tries, roots := schemeVersions(t, s, 5)
+
+	_, err := s.Open(roots[2])
+	require.ErrorIs(t, err, ErrStaleRoot)
+
+	// only the 3 latest commits can be undone
+	require.ErrorIs(t, s.Rollback(roots[0]), ErrUnknownRoot)
+
+	require.NoError(t, s.Rollback(roots[3]))
+	require.NoError(t, s.Rollback(roots[3]))
+
+	opened, err := s.Open(roots[3])
+	require.NoError(t, err)
+	requireSameKeys(t, tries[3], opened)
+
+	require.NoError(t, s.Rollback(roots[1]))
+	opened, err = s.Open(roots[1])
+	require.NoError(t, err)
+	requireSameKeys(t, tries[1], opened)
+
+	require.ErrorIs(t, s.Rollback(roots[4]), ErrUnknownRoot)
+
+	// the storage matches the one of the version committed at once
+	fresh := NewInMemoryStorage()
+	_, err = NewPathScheme(fresh, nil, 1).Commit(tries[1])
+	require.NoError(t, err)
+	require.Equal(t, storedNodes(t, fresh), storedNodes(t, db))
+}
+
+func TestPathScheme_ShouldDetectStaleReads(t *testing.T) {
+	s := NewPathScheme(NewInMemoryStorage(), nil, 1)
+	_, roots := schemeVersions(t, s, 1)
+
+	before, err := s.Open(roots[0])
+	require.NoError(t, err)
+
+	next, err := s.Open(roots[0])
+	require.NoError(t, err)
+	require.NoError(t, next.Put([]byte("key-050"), []byte("changed")))
+	_, err = s.Commit(next)
+	require.NoError(t, err)
+
+	_, _, err = before.TryGet([]byte("key-050"))
+	require.ErrorIs(t, err, ErrStaleNode)
+}
+
+func TestPathScheme_ShouldKeepOwnersApart(t *testing.T) {
+	db := NewInMemoryStorage()
+	first := NewPathScheme(db, []byte("first"), 1)
+	second := NewPathScheme(db, []byte("second"), 1, WithHashedValues(40, nil))
+
+	a := buildTrie(t, namespacedKeys)
+	rootA, err := first.Commit(a)
+	require.NoError(t, err)
+
+	b := NewTrie(WithHashedValues(40, nil))
+	long := []byte(fmt.Sprintf("%064d", 42))
+	require.NoError(t, b.Put([]byte("long"), long))
+	require.NoError(t, b.Put([]byte("short"), []byte("1")))
+	rootB, err := second.Commit(b)
+	require.NoError(t, err)
+
+	openedA, err := first.Open(rootA)
+	require.NoError(t, err)
+	for _, k := range namespacedKeys {
+		v, ok, err := openedA.TryGet([]byte(k))
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, []byte("value of "+k), v)
+	}
+
+	openedB, err := second.Open(rootB)
+	require.NoError(t, err)
+	v, ok, err := openedB.TryGet([]byte("long"))
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, long, v)
+
+	_, err = first.Open(rootB)
+	require.ErrorIs(t, err, ErrStaleRoot)
+}
+
+func TestPathScheme_ShouldRejectTriesOpenedAtAnOlderRoot(t *testing.T) {
+	db := NewInMemoryStorage()
+	s := NewPathScheme(db, nil, 2)
+	tries, roots := schemeVersions(t, s, 1)
+
+	first, err := s.Open(roots[0])
+	require.NoError(t, err)
+
+	second, err := s.Open(roots[0])
+	require.NoError(t, err)
+
+	require.NoError(t, first.Put([]byte("key-050"), []byte("changed")))
+	latest, err := s.Commit(first)
+	require.NoError(t, err)
+
+	// neither the unchanged trie nor one changed after a stale read is
+	// committed over the latest root
+	_, err = s.Commit(second)
+	require.ErrorIs(t, err, ErrStaleRoot)
+
+	require.ErrorIs(t, second.Put([]byte("key-050"), []byte("other")), ErrStaleNode)
+	_, err = s.Commit(second)
+	require.ErrorIs(t, err, ErrStaleRoot)
+
+	root, err := s.Root()
+	require.NoError(t, err)
+	require.Equal(t, latest, root)
+
+	expected := tries[0].Copy()
+	require.NoError(t, expected.Put([]byte("key-050"), []byte("changed")))
+	opened, err := s.Open(latest)
+	require.NoError(t, err)
+	requireSameKeys(t, expected, opened)
+
+	// the committed trie moves on to the latest root
+	require.NoError(t, first.Put([]byte("key-051"), []byte("changed too")))
+	_, err = s.Commit(first)
+	require.NoError(t, err)
+}
@@ -0,0 +1,427 @@
+package mptrie
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"sync"
+)
+
+var (
+	ErrUnknownSnapshot  = errors.New("no snapshot layer for the root")
+	ErrSnapshotStale    = errors.New("snapshot layer was flattened into the disk layer")
+	ErrSnapshotMismatch = errors.New("snapshot content does not match its root")
+)
+
+const (
+	snapshotPrefix  = 's'
+	snapshotRootKey = 'r'
+)
+
+// Snapshot is a flat view of the keys and values of the trie with root Root,
+// reading a key takes a lookup per layer instead of a walk through the nodes.
+type Snapshot interface {
+	Root() []byte
+	Get(key []byte) ([]byte, bool, error)
+}
+
+// SnapshotTree keeps snapshots of the latest roots of a trie as diff layers in
+// memory stacked on a disk layer, the keys and values of one root stored in a
+// Database of their own. Each commit adds a diff layer with the changes it
+// made and Cap flattens the oldest layers into the disk layer.
+type SnapshotTree struct {
+	db    Database
+	nodes NodeReader
+	opts  []Option
+
+	lock   sync.RWMutex
+	disk   *diskLayer
+	layers map[string]*diffLayer
+}
+
+type diskLayer struct {
+	tree  *SnapshotTree
+	root  []byte
+	stale bool
+}
+
+// diffLayer holds the values its commit changed on top of parent, removed
+// keys have a nil value
+type diffLayer struct {
+	tree    *SnapshotTree
+	parent  Snapshot
+	root    []byte
+	changes map[string][]byte
+	stale   bool
+}
+
+// NewSnapshotTree returns the snapshots stored in db, whose disk layer is
+// rebuilt from the trie with root in nodes when it is not at root already.
+// opts must match the ones of the trie.
+func NewSnapshotTree(db Database, nodes NodeReader, root []byte, opts ...Option) (*SnapshotTree, error) {
+	st := &SnapshotTree{db: db, nodes: nodes, opts: opts, layers: make(map[string]*diffLayer)}
+
+	stored, err := db.Get([]byte{snapshotRootKey})
+	if err != nil && !errors.Is(err, KeyNotFound) {
+		return nil, err
+	}
+
+	if !bytes.Equal(stored, root) {
+		if err := st.generate(root); err != nil {
+			return nil, err
+		}
+	}
+
+	st.disk = &diskLayer{tree: st, root: copyBytes(root)}
+	return st, nil
+}
+
+// generate replaces the disk layer with the keys of the trie at root
+func (st *SnapshotTree) generate(root []byte) error {
+	it := st.db.NewIterator([]byte{snapshotPrefix}, nil)
+	var old [][]byte
+	for it.Next() {
+		old = append(old, copyBytes(it.Key()))
+	}
+
+	it.Release()
+	if err := it.Error(); err != nil {
+		return err
+	}
+
+	return writeBatch(st.db, func(w KVWriter) error {
+		for _, key := range old {
+			if err := w.Delete(key); err != nil {
+				return err
+			}
+		}
+
+		err := Diff(NewTrie(st.opts...), OpenTrie(root, st.nodes, st.opts...), func(c Change) error {
+			return w.Put(snapshotKey(c.Key), c.To)
+		})
+
+		if err != nil {
+			return err
+		}
+
+		return w.Put([]byte{snapshotRootKey}, root)
+	})
+}
+
+func snapshotKey(key []byte) []byte {
+	return append([]byte{snapshotPrefix}, key...)
+}
+
+// WithSnapshot makes Get and TryGet read from snap instead of walking the
+// nodes while the root of the trie is the root of snap, that is until the trie
+// is changed. A stale snapshot falls back to the nodes.
+func WithSnapshot(snap Snapshot) Option {
+	return func(t *Trie) {
+		t.snapshot = snap
+	}
+}
+
+// snapshotGet reads key from the snapshot of the trie, served is false when
+// the snapshot cannot answer for the current root
+func (t Trie) snapshotGet(key []byte) (value []byte, ok, served bool, err error) {
+	if t.snapshot == nil {
+		return nil, false, false, nil
+	}
+
+	hash, isHash := t.root.(HashNode)
+	if !isHash || !bytes.Equal(hash, t.snapshot.Root()) {
+		return nil, false, false, nil
+	}
+
+	value, ok, err = t.snapshot.Get(key)
+	if errors.Is(err, ErrSnapshotStale) {
+		return nil, false, false, nil
+	}
+
+	return value, ok, true, err
+}
+
+// OpenTrie opens the trie at root from the nodes of the tree, reading from
+// the snapshot of root when there is one
+func (st *SnapshotTree) OpenTrie(root []byte) *Trie {
+	opts := st.opts
+	if snap := st.Snapshot(root); snap != nil {
+		opts = append(append([]Option{}, st.opts...), WithSnapshot(snap))
+	}
+
+	return OpenTrie(root, st.nodes, opts...)
+}
+
+// Snapshot returns the snapshot of root, or nil when there is none
+func (st *SnapshotTree) Snapshot(root []byte) Snapshot {
+	st.lock.RLock()
+	defer st.lock.RUnlock()
+
+	return st.layer(root)
+}
+
+func (st *SnapshotTree) layer(root []byte) Snapshot {
+	if bytes.Equal(root, st.disk.root) {
+		return st.disk
+	}
+
+	if diff, ok := st.layers[string(root)]; ok {
+		return diff
+	}
+
+	return nil
+}
+
+// Update adds the snapshot of root made of changes on top of the snapshot of
+// parent
+func (st *SnapshotTree) Update(root, parent []byte, changes []Change) error {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	base := st.layer(parent)
+	if base == nil {
+		return ErrUnknownSnapshot
+	}
+
+	if st.layer(root) != nil {
+		return nil
+	}
+
+	diff := &diffLayer{tree: st, parent: base, root: copyBytes(root), changes: make(map[string][]byte, len(changes))}
+	for _, c := range changes {
+		if c.Kind == Removed {
+			diff.changes[string(c.Key)] = nil
+		} else {
+			diff.changes[string(c.Key)] = copyBytes(c.To)
+		}
+	}
+
+	st.layers[string(root)] = diff
+	return nil
+}
+
+// UpdateTrie adds the snapshot of t on top of the snapshot of parent, the
+// changes are found by comparing t with the trie at parent in the nodes.
+func (st *SnapshotTree) UpdateTrie(parent []byte, t *Trie) error {
+	var changes []Change
+	err := Diff(OpenTrie(parent, st.nodes, st.opts...), t, func(c Change) error {
+		changes = append(changes, c)
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return st.Update(t.Hash(), parent, changes)
+}
+
+// Cap keeps at most layers diff layers below root and flattens the older ones
+// into the disk layer in a single batch. Layers that do not descend from the
+// new disk layer are dropped and so are the ones flattened, reading from them
+// returns ErrSnapshotStale.
+func (st *SnapshotTree) Cap(root []byte, layers int) error {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	top := st.layer(root)
+	if top == nil {
+		return ErrUnknownSnapshot
+	}
+
+	chain := diffChain(top)
+
+	if len(chain) <= layers {
+		return nil
+	}
+
+	// the bottom layers are flattened oldest first so newer changes win
+	flattened := chain[layers:]
+	merged := make(map[string][]byte)
+	for i := len(flattened) - 1; i >= 0; i-- {
+		for key, value := range flattened[i].changes {
+			merged[key] = value
+		}
+	}
+
+	newest := flattened[0]
+	err := writeBatch(st.db, func(w KVWriter) error {
+		for key, value := range merged {
+			var err error
+			if value == nil {
+				err = w.Delete(snapshotKey([]byte(key)))
+			} else {
+				err = w.Put(snapshotKey([]byte(key)), value)
+			}
+
+			if err != nil {
+				return err
+			}
+		}
+
+		return w.Put([]byte{snapshotRootKey}, newest.root)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	st.disk.stale = true
+	st.disk = &diskLayer{tree: st, root: newest.root}
+
+	kept := make(map[string]*diffLayer)
+	if layers > 0 {
+		chain[layers-1].parent = st.disk
+	}
+
+	for key, diff := range st.layers {
+		if descends(diff, st.disk) {
+			kept[key] = diff
+		} else {
+			diff.stale = true
+		}
+	}
+
+	st.layers = kept
+	return nil
+}
+
+// diffChain returns the diff layers from top down to the disk layer
+func diffChain(top Snapshot) []*diffLayer {
+	var chain []*diffLayer
+	for diff, ok := top.(*diffLayer); ok; diff, ok = diff.parent.(*diffLayer) {
+		chain = append(chain, diff)
+	}
+
+	return chain
+}
+
+// descends reports whether the chain of parents of diff reaches disk
+func descends(diff *diffLayer, disk *diskLayer) bool {
+	for l := Snapshot(diff); ; {
+		switch layer := l.(type) {
+		case *diffLayer:
+			if layer.stale {
+				return false
+			}
+
+			l = layer.parent
+		case *diskLayer:
+			return layer == disk
+		}
+	}
+}
+
+// Verify rebuilds the trie from the keys in the snapshot of root and checks
+// that it has the same root
+func (st *SnapshotTree) Verify(root []byte) error {
+	st.lock.RLock()
+	defer st.lock.RUnlock()
+
+	top := st.layer(root)
+	if top == nil {
+		return ErrUnknownSnapshot
+	}
+
+	chain := diffChain(top)
+
+	values := make(map[string][]byte)
+	it := st.db.NewIterator([]byte{snapshotPrefix}, nil)
+	for it.Next() {
+		values[string(it.Key()[1:])] = copyBytes(it.Value())
+	}
+
+	it.Release()
+	if err := it.Error(); err != nil {
+		return err
+	}
+
+	for i := len(chain) - 1; i >= 0; i-- {
+		for key, value := range chain[i].changes {
+			if value == nil {
+				delete(values, key)
+			} else {
+				values[key] = value
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	t := NewTrie(st.opts...)
+	for _, key := range keys {
+		if err := t.Put([]byte(key), values[key]); err != nil {
+			return err
+		}
+	}
+
+	if !bytes.Equal(t.Hash(), root) {
+		return ErrSnapshotMismatch
+	}
+
+	return nil
+}
+
+func (l *diskLayer) Root() []byte {
+	return l.root
+}
+
+func (l *diskLayer) Get(key []byte) ([]byte, bool, error) {
+	l.tree.lock.RLock()
+	defer l.tree.lock.RUnlock()
+
+	return l.get(key)
+}
+
+func (l *diskLayer) get(key []byte) ([]byte, bool, error) {
+	if l.stale {
+		return nil, false, ErrSnapshotStale
+	}
+
+	value, err := l.tree.db.Get(snapshotKey(key))
+	if errors.Is(err, KeyNotFound) {
+		return nil, false, nil
+	}
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	return value, true, nil
+}
+
+func (l *diffLayer) Root() []byte {
+	return l.root
+}
+
+// Get looks for key in every layer down to the disk layer
+func (l *diffLayer) Get(key []byte) ([]byte, bool, error) {
+	l.tree.lock.RLock()
+	defer l.tree.lock.RUnlock()
+
+	for layer := Snapshot(l); ; {
+		switch current := layer.(type) {
+		case *diffLayer:
+			if current.stale {
+				return nil, false, ErrSnapshotStale
+			}
+
+			if value, ok := current.changes[string(key)]; ok {
+				if value == nil {
+					return nil, false, nil
+				}
+
+				return copyBytes(value), true, nil
+			}
+
+			layer = current.parent
+		case *diskLayer:
+			return current.get(key)
+		}
+	}
+}
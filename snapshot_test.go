@@ -0,0 +1,167 @@
+package mptrie
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// snapshotVersions commits a version per round into nodes and adds its
+// snapshot on top of the previous one
+func snapshotVersions(t *testing.T, st *SnapshotTree, nodes KVWriter, trie *Trie, rounds int) ([]*Trie, [][]byte) {
+	var tries []*Trie
+	var roots [][]byte
+
+	parent := trie.Hash()
+	for round := 0; round < rounds; round++ {
+		for i := round; i < 100; i += 4 {
+			key := []byte(fmt.Sprintf("account-%03d", i))
+			require.NoError(t, trie.Put(key, []byte(fmt.Sprintf("balance %d at round %d", i, round))))
+		}
+
+		require.NoError(t, trie.Delete([]byte(fmt.Sprintf("account-%03d", round*10+1))))
+
+		root, err := trie.Commit(nodes)
+		require.NoError(t, err)
+		require.NoError(t, st.UpdateTrie(parent, trie))
+
+		tries = append(tries, trie.Copy())
+		roots = append(roots, root)
+		parent = root
+	}
+
+	return tries, roots
+}
+
+func requireSnapshotContent(t *testing.T, expected *Trie, snap Snapshot) {
+	for i := 0; i < 110; i++ {
+		key := []byte(fmt.Sprintf("account-%03d", i))
+		want, wantOK := expected.Get(key)
+
+		got, ok, err := snap.Get(key)
+		require.NoError(t, err)
+		require.Equal(t, wantOK, ok, string(key))
+		require.Equal(t, want, got, string(key))
+	}
+}
+
+func TestSnapshotTree_ShouldServeEveryLayer(t *testing.T) {
+	nodes, db := NewInMemoryStorage(), NewInMemoryStorage()
+
+	trie := NewTrie()
+	for i := 0; i < 100; i++ {
+		require.NoError(t, trie.Put([]byte(fmt.Sprintf("account-%03d", i)), []byte("initial")))
+	}
+
+	base, err := trie.Commit(nodes)
+	require.NoError(t, err)
+
+	st, err := NewSnapshotTree(db, nodes, base)
+	require.NoError(t, err)
+	requireSnapshotContent(t, trie.Copy(), st.Snapshot(base))
+
+	tries, roots := snapshotVersions(t, st, nodes, trie, 4)
+	for i, root := range roots {
+		snap := st.Snapshot(root)
+		require.Equal(t, root, snap.Root())
+		requireSnapshotContent(t, tries[i], snap)
+		require.NoError(t, st.Verify(root))
+	}
+
+	require.Nil(t, st.Snapshot([]byte("unknown")))
+	require.ErrorIs(t, st.Update(roots[0], []byte("unknown"), nil), ErrUnknownSnapshot)
+}
+
+func TestSnapshotTree_CapShouldFlattenOldLayers(t *testing.T) {
+	nodes, db := NewInMemoryStorage(), NewInMemoryStorage()
+	trie := buildTrie(t, namespacedKeys)
+	base, err := trie.Commit(nodes)
+	require.NoError(t, err)
+
+	st, err := NewSnapshotTree(db, nodes, base)
+	require.NoError(t, err)
+
+	tries, roots := snapshotVersions(t, st, nodes, trie, 4)
+
+	// a fork from the first version is dropped once it is flattened
+	fork := tries[0].Copy()
+	require.NoError(t, fork.Put([]byte("forked"), []byte("yes")))
+	require.NoError(t, st.UpdateTrie(roots[0], fork))
+	forked := st.Snapshot(fork.Hash())
+
+	old := st.Snapshot(roots[1])
+	require.NoError(t, st.Cap(roots[3], 1))
+
+	_, _, err = old.Get([]byte("account-001"))
+	require.ErrorIs(t, err, ErrSnapshotStale)
+
+	_, _, err = forked.Get([]byte("forked"))
+	require.ErrorIs(t, err, ErrSnapshotStale)
+	require.Nil(t, st.Snapshot(roots[0]))
+
+	requireSnapshotContent(t, tries[2], st.Snapshot(roots[2]))
+	requireSnapshotContent(t, tries[3], st.Snapshot(roots[3]))
+	require.NoError(t, st.Verify(roots[2]))
+	require.NoError(t, st.Verify(roots[3]))
+
+	// the disk layer is reopened without being rebuilt from the nodes
+	st, err = NewSnapshotTree(db, NewInMemoryStorage(), roots[2])
+	require.NoError(t, err)
+	requireSnapshotContent(t, tries[2], st.Snapshot(roots[2]))
+
+	require.NoError(t, db.Put(snapshotKey([]byte("account-002")), []byte("tampered")))
+	require.ErrorIs(t, st.Verify(roots[2]), ErrSnapshotMismatch)
+
+	// a snapshot at another root is rebuilt
+	st, err = NewSnapshotTree(db, nodes, roots[3])
+	require.NoError(t, err)
+	requireSnapshotContent(t, tries[3], st.Snapshot(roots[3]))
+	require.NoError(t, st.Verify(roots[3]))
+}
+
+// trieSnapshot reads a trie as a Snapshot, so it is checked like one
+type trieSnapshot struct {
+	*Trie
+}
+
+func (s trieSnapshot) Root() []byte {
+	return s.Hash()
+}
+
+func (s trieSnapshot) Get(key []byte) ([]byte, bool, error) {
+	return s.TryGet(key)
+}
+
+func TestSnapshotTree_OpenTrieShouldReadFromTheSnapshot(t *testing.T) {
+	nodes, db := NewInMemoryStorage(), NewInMemoryStorage()
+	trie := buildTrie(t, namespacedKeys)
+	base, err := trie.Commit(nodes)
+	require.NoError(t, err)
+
+	st, err := NewSnapshotTree(db, nodes, base)
+	require.NoError(t, err)
+
+	tries, roots := snapshotVersions(t, st, nodes, trie, 3)
+	for i, root := range roots {
+		requireSnapshotContent(t, tries[i], trieSnapshot{st.OpenTrie(root)})
+	}
+
+	// no node is needed to read through the snapshot
+	snap := st.Snapshot(roots[2])
+	opened := OpenTrie(roots[2], NewInMemoryStorage(), WithSnapshot(snap))
+	requireSnapshotContent(t, tries[2], trieSnapshot{opened})
+
+	// the snapshot only answers while the trie is at its root
+	opened = st.OpenTrie(roots[2])
+	require.NoError(t, opened.Put([]byte("account-005"), []byte("changed")))
+	v, ok, err := opened.TryGet([]byte("account-005"))
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, []byte("changed"), v)
+
+	// a stale snapshot falls back to the nodes
+	old := st.OpenTrie(roots[0])
+	require.NoError(t, st.Cap(roots[2], 0))
+	requireSnapshotContent(t, tries[0], trieSnapshot{old})
+}
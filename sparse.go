@@ -0,0 +1,450 @@
+package mptrie
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// SparseDepth is the number of levels of a SparseMerkleTree, one per key bit
+const SparseDepth = 256
+
+var (
+	ErrInvalidSparseKey = errors.New("sparse merkle tree keys must have 32 bytes")
+)
+
+// SparseLeafNode holds the value of a key at the bottom of a SparseMerkleTree
+type SparseLeafNode struct {
+	Key   []byte
+	Value []byte
+}
+
+// SparseBranchNode is an inner node of a SparseMerkleTree at the given height,
+// a nil child is an empty subtree whose hash is the default one for its
+// height. The hash is computed when the node is built, nodes never change.
+type SparseBranchNode struct {
+	Children [2]Node
+	Height   int
+
+	hash []byte
+}
+
+func (l SparseLeafNode) Hash() []byte {
+	return defaultSparseHasher.hash(&l, 0)
+}
+
+func (l SparseLeafNode) Raw() []interface{} {
+	return defaultSparseHasher.raw(&l)
+}
+
+func (b SparseBranchNode) Hash() []byte {
+	if b.hash != nil {
+		return b.hash
+	}
+
+	return defaultSparseHasher.hash(&b, b.Height)
+}
+
+func (b SparseBranchNode) Raw() []interface{} {
+	return defaultSparseHasher.raw(&b)
+}
+
+// sparseHasher hashes the nodes of a SparseMerkleTree, defaults[h] is the
+// hash of an empty subtree of height h
+type sparseHasher struct {
+	hasher   Hasher
+	defaults [SparseDepth + 1][]byte
+}
+
+var defaultSparseHasher = newSparseHasher(Keccak256)
+
+func newSparseHasher(h Hasher) *sparseHasher {
+	s := &sparseHasher{hasher: h}
+	s.defaults[0] = h.Hash(EmptyNodeRaw)
+
+	for i := 1; i <= SparseDepth; i++ {
+		s.defaults[i] = s.serializeAndHash([]interface{}{s.defaults[i-1], s.defaults[i-1]})
+	}
+
+	return s
+}
+
+func (s *sparseHasher) raw(n Node) []interface{} {
+	switch node := n.(type) {
+	case *SparseLeafNode:
+		return []interface{}{node.Key, node.Value}
+	case *SparseBranchNode:
+		return []interface{}{
+			s.hash(node.Children[0], node.Height-1),
+			s.hash(node.Children[1], node.Height-1),
+		}
+	}
+
+	panic(fmt.Sprintf("sparse merkle tree: cannot encode %T", n))
+}
+
+func (s *sparseHasher) serialize(n Node) []byte {
+	b, err := rlp.EncodeToBytes(s.raw(n))
+	if err != nil {
+		panic(err)
+	}
+
+	return b
+}
+
+func (s *sparseHasher) serializeAndHash(raw []interface{}) []byte {
+	b, err := rlp.EncodeToBytes(raw)
+	if err != nil {
+		panic(err)
+	}
+
+	return s.hasher.Hash(b)
+}
+
+// hash returns the hash of n as a subtree of the given height
+func (s *sparseHasher) hash(n Node, height int) []byte {
+	switch node := n.(type) {
+	case nil:
+		return s.defaults[height]
+	case HashNode:
+		return node
+	case *SparseBranchNode:
+		if node.hash == nil {
+			node.hash = s.hasher.Hash(s.serialize(node))
+		}
+
+		return node.hash
+	}
+
+	return s.hasher.Hash(s.serialize(n))
+}
+
+// branch builds the node joining left and right, two empty subtrees make an
+// empty subtree
+func (s *sparseHasher) branch(height int, left, right Node) Node {
+	if left == nil && right == nil {
+		return nil
+	}
+
+	b := &SparseBranchNode{Children: [2]Node{left, right}, Height: height}
+	s.hash(b, height)
+	return b
+}
+
+// decode rebuilds the node of the given height from its encoding, the
+// children are returned as HashNode or nil when empty
+func (s *sparseHasher) decode(b []byte, height int) (Node, error) {
+	var items [][]byte
+	if err := rlp.DecodeBytes(b, &items); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidNode, err)
+	}
+
+	if len(items) != 2 {
+		return nil, fmt.Errorf("%w: list with %d items", ErrInvalidNode, len(items))
+	}
+
+	if height == 0 {
+		return &SparseLeafNode{Key: items[0], Value: items[1]}, nil
+	}
+
+	branch := &SparseBranchNode{Height: height}
+	for i, ref := range items {
+		if bytes.Equal(ref, s.defaults[height-1]) {
+			continue
+		}
+
+		branch.Children[i] = HashNode(ref)
+	}
+
+	s.hash(branch, height)
+	return branch, nil
+}
+
+// SparseMerkleTree maps 32 byte keys, usually hashes, to values in a binary
+// tree of fixed depth where every key has its own leaf. Empty subtrees are
+// not kept, their hashes are known ahead for each height.
+type SparseMerkleTree struct {
+	root Node
+	db   NodeReader
+	s    *sparseHasher
+}
+
+// NewSparseMerkleTree builds an empty tree, WithHasher is the only option
+// that applies to it.
+func NewSparseMerkleTree(opts ...Option) *SparseMerkleTree {
+	h := NewTrie(opts...).encoder().hasher
+	if h == Keccak256 {
+		return &SparseMerkleTree{s: defaultSparseHasher}
+	}
+
+	return &SparseMerkleTree{s: newSparseHasher(h)}
+}
+
+// OpenSparseMerkleTree returns the tree with the given root whose nodes were
+// written to db by Commit, nodes are loaded when an operation reaches them.
+func OpenSparseMerkleTree(root []byte, db NodeReader, opts ...Option) *SparseMerkleTree {
+	t := NewSparseMerkleTree(opts...)
+	t.db = db
+
+	if !bytes.Equal(root, t.s.defaults[SparseDepth]) {
+		t.root = HashNode(copyBytes(root))
+	}
+
+	return t
+}
+
+func (t *SparseMerkleTree) Hash() []byte {
+	return t.s.hash(t.root, SparseDepth)
+}
+
+// resolve loads n from storage when it is a HashNode
+func (t *SparseMerkleTree) resolve(n Node, height int) (Node, error) {
+	hash, ok := n.(HashNode)
+	if !ok {
+		return n, nil
+	}
+
+	if t.db == nil {
+		return nil, ErrMissingStorage
+	}
+
+	encoded, err := t.db.Get(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.s.decode(encoded, height)
+}
+
+// path returns the nodes met from the root down to the leaf of key, path[h] is
+// the node at height h or nil when that subtree is empty
+func (t *SparseMerkleTree) path(key []byte) ([SparseDepth + 1]Node, error) {
+	var path [SparseDepth + 1]Node
+
+	if len(key) != SparseDepth/8 {
+		return path, ErrInvalidSparseKey
+	}
+
+	bits := BitsFromBytes(key)
+	node := t.root
+
+	for height := SparseDepth; height >= 0 && node != nil; height-- {
+		n, err := t.resolve(node, height)
+		if err != nil {
+			return path, err
+		}
+
+		path[height] = n
+		if branch, ok := n.(*SparseBranchNode); ok {
+			node = branch.Children[bits[SparseDepth-height]]
+		}
+	}
+
+	return path, nil
+}
+
+// TryGet returns the value of key, ok is false when the key is not set
+func (t *SparseMerkleTree) TryGet(key []byte) ([]byte, bool, error) {
+	path, err := t.path(key)
+	if err != nil {
+		return nil, false, err
+	}
+
+	leaf, ok := path[0].(*SparseLeafNode)
+	if !ok {
+		return nil, false, nil
+	}
+
+	return leaf.Value, true, nil
+}
+
+func (t *SparseMerkleTree) Get(key []byte) ([]byte, bool) {
+	value, ok, err := t.TryGet(key)
+	if err != nil {
+		return nil, false
+	}
+
+	return value, ok
+}
+
+// Put sets the value of key, an empty value removes the key
+func (t *SparseMerkleTree) Put(key, value []byte) error {
+	path, err := t.path(key)
+	if err != nil {
+		return err
+	}
+
+	var node Node
+	if len(value) > 0 {
+		node = &SparseLeafNode{Key: copyBytes(key), Value: value}
+	}
+
+	bits := BitsFromBytes(key)
+	for height := 1; height <= SparseDepth; height++ {
+		bit := bits[SparseDepth-height]
+
+		var sibling Node
+		if branch, ok := path[height].(*SparseBranchNode); ok {
+			sibling = branch.Children[1-bit]
+		}
+
+		if bit == 0 {
+			node = t.s.branch(height, node, sibling)
+		} else {
+			node = t.s.branch(height, sibling, node)
+		}
+	}
+
+	t.root = node
+	return nil
+}
+
+// Delete removes key from the tree, deleting a key that is not set is not an
+// error.
+func (t *SparseMerkleTree) Delete(key []byte) error {
+	return t.Put(key, nil)
+}
+
+// Commit writes every node of the tree into w keyed by its hash and returns
+// the root hash. Nodes that were never loaded from storage are expected to
+// already be in w. Like Trie.Commit, a Batcher gets all nodes in one batch.
+func (t *SparseMerkleTree) Commit(w KVWriter) ([]byte, error) {
+	err := writeBatch(w, func(w KVWriter) error {
+		return t.commit(t.root, w)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return t.Hash(), nil
+}
+
+func (t *SparseMerkleTree) commit(n Node, w KVWriter) error {
+	switch node := n.(type) {
+	case nil, HashNode:
+		return nil
+	case *SparseBranchNode:
+		for _, child := range node.Children {
+			if err := t.commit(child, w); err != nil {
+				return err
+			}
+		}
+
+		return w.Put(node.hash, t.s.serialize(node))
+	}
+
+	return w.Put(t.s.hash(n, 0), t.s.serialize(n))
+}
+
+// SparseProof proves the value of a key, or that it is not set when Value is
+// nil. Only the siblings whose subtree is not empty are kept, bit i of Bitmap
+// (most significant first) tells whether the sibling at depth i is in
+// Siblings, which are ordered from the root down.
+type SparseProof struct {
+	Bitmap   []byte
+	Siblings [][]byte
+	Value    []byte
+}
+
+// Prove builds the inclusion proof of key, or its non-inclusion proof when
+// the key is not set.
+func (t *SparseMerkleTree) Prove(key []byte) (*SparseProof, error) {
+	path, err := t.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	proof := &SparseProof{Bitmap: make([]byte, SparseDepth/8)}
+	bits := BitsFromBytes(key)
+
+	for height := SparseDepth; height >= 1; height-- {
+		branch, ok := path[height].(*SparseBranchNode)
+		if !ok {
+			break
+		}
+
+		depth := SparseDepth - height
+		sibling := t.s.hash(branch.Children[1-bits[depth]], height-1)
+		if bytes.Equal(sibling, t.s.defaults[height-1]) {
+			continue
+		}
+
+		proof.Bitmap[depth/8] |= 1 << uint(7-depth%8)
+		proof.Siblings = append(proof.Siblings, sibling)
+	}
+
+	if leaf, ok := path[0].(*SparseLeafNode); ok {
+		proof.Value = leaf.Value
+	}
+
+	return proof, nil
+}
+
+// VerifySparseProof checks that proof leads from the leaf of key to root, with
+// the value in the proof or without any value for a non-inclusion proof. The
+// options must match the ones of the proved tree.
+func VerifySparseProof(root, key []byte, proof *SparseProof, opts ...Option) error {
+	if len(key) != SparseDepth/8 {
+		return ErrInvalidSparseKey
+	}
+
+	if len(proof.Bitmap) != SparseDepth/8 {
+		return ErrWhileProof
+	}
+
+	s := NewSparseMerkleTree(opts...).s
+	bits := BitsFromBytes(key)
+
+	node := s.defaults[0]
+	if len(proof.Value) > 0 {
+		node = s.hash(&SparseLeafNode{Key: key, Value: proof.Value}, 0)
+	}
+
+	siblings := proof.Siblings
+	for height := 1; height <= SparseDepth; height++ {
+		depth := SparseDepth - height
+
+		sibling := s.defaults[height-1]
+		if proof.Bitmap[depth/8]&(1<<uint(7-depth%8)) != 0 {
+			if len(siblings) == 0 {
+				return ErrWhileProof
+			}
+
+			sibling, siblings = siblings[len(siblings)-1], siblings[:len(siblings)-1]
+		}
+
+		if bits[depth] == 0 {
+			node = s.serializeAndHash([]interface{}{node, sibling})
+		} else {
+			node = s.serializeAndHash([]interface{}{sibling, node})
+		}
+	}
+
+	if len(siblings) > 0 || !bytes.Equal(node, root) {
+		return ErrWhileProof
+	}
+
+	return nil
+}
+
+// Encode returns the binary (RLP) form of the proof
+func (p *SparseProof) Encode() ([]byte, error) {
+	return rlp.EncodeToBytes(p)
+}
+
+// DecodeSparseProof rebuilds a proof from the output of Encode
+func DecodeSparseProof(b []byte) (*SparseProof, error) {
+	var p SparseProof
+	if err := rlp.DecodeBytes(b, &p); err != nil {
+		return nil, err
+	}
+
+	if len(p.Value) == 0 {
+		p.Value = nil
+	}
+
+	return &p, nil
+}
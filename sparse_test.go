@@ -0,0 +1,137 @@
+package mptrie
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func sparseKey(i int) []byte {
+	return Keccak256.Hash([]byte(fmt.Sprintf("key-%d", i)))
+}
+
+func buildSparseTree(t *testing.T, n int, opts ...Option) *SparseMerkleTree {
+	tree := NewSparseMerkleTree(opts...)
+	for i := 0; i < n; i++ {
+		require.NoError(t, tree.Put(sparseKey(i), []byte(fmt.Sprintf("value-%d", i))))
+	}
+
+	return tree
+}
+
+func TestSparseMerkleTree_PutGetDelete(t *testing.T) {
+	empty := NewSparseMerkleTree().Hash()
+	require.Equal(t, defaultSparseHasher.defaults[SparseDepth], empty)
+
+	tree := buildSparseTree(t, 200)
+	require.NotEqual(t, empty, tree.Hash())
+
+	for i := 0; i < 200; i++ {
+		v, ok := tree.Get(sparseKey(i))
+		require.True(t, ok)
+		require.Equal(t, []byte(fmt.Sprintf("value-%d", i)), v)
+	}
+
+	_, ok := tree.Get(sparseKey(200))
+	require.False(t, ok)
+
+	_, _, err := tree.TryGet([]byte("short"))
+	require.ErrorIs(t, err, ErrInvalidSparseKey)
+
+	// the root only depends on the content
+	shuffled := NewSparseMerkleTree()
+	for _, i := range rand.New(rand.NewSource(37)).Perm(200) {
+		require.NoError(t, shuffled.Put(sparseKey(i), []byte(fmt.Sprintf("value-%d", i))))
+	}
+
+	require.Equal(t, tree.Hash(), shuffled.Hash())
+
+	for i := 0; i < 200; i++ {
+		require.NoError(t, tree.Delete(sparseKey(i)))
+	}
+
+	require.NoError(t, tree.Delete(sparseKey(200)))
+	require.Equal(t, empty, tree.Hash())
+	require.Nil(t, tree.root)
+}
+
+func TestSparseMerkleTree_Proofs(t *testing.T) {
+	tree := buildSparseTree(t, 100, WithHasher(SHA256))
+	root := tree.Hash()
+
+	for _, i := range []int{0, 42, 99, 100, 1000} {
+		proof, err := tree.Prove(sparseKey(i))
+		require.NoError(t, err)
+
+		if i < 100 {
+			require.Equal(t, []byte(fmt.Sprintf("value-%d", i)), proof.Value)
+		} else {
+			require.Nil(t, proof.Value)
+		}
+
+		// only the siblings near the top are not empty
+		require.Less(t, len(proof.Siblings), 20)
+
+		encoded, err := proof.Encode()
+		require.NoError(t, err)
+		require.Less(t, len(encoded), 32*20+64)
+
+		decoded, err := DecodeSparseProof(encoded)
+		require.NoError(t, err)
+		require.NoError(t, VerifySparseProof(root, sparseKey(i), decoded, WithHasher(SHA256)))
+
+		// the same proof does not hold for another key or hasher
+		require.ErrorIs(t, VerifySparseProof(root, sparseKey(i+1), decoded, WithHasher(SHA256)), ErrWhileProof)
+		require.ErrorIs(t, VerifySparseProof(root, sparseKey(i), decoded), ErrWhileProof)
+	}
+
+	proof, err := tree.Prove(sparseKey(7))
+	require.NoError(t, err)
+
+	// a forged value or a false claim of absence are rejected
+	proof.Value = []byte("forged")
+	require.ErrorIs(t, VerifySparseProof(root, sparseKey(7), proof, WithHasher(SHA256)), ErrWhileProof)
+
+	proof.Value = nil
+	require.ErrorIs(t, VerifySparseProof(root, sparseKey(7), proof, WithHasher(SHA256)), ErrWhileProof)
+}
+
+func TestSparseMerkleTree_ShouldCommitNextToATrie(t *testing.T) {
+	db := NewInMemoryStorage()
+
+	tree := buildSparseTree(t, 50)
+	root, err := tree.Commit(db)
+	require.NoError(t, err)
+	require.Equal(t, tree.Hash(), root)
+
+	trie := buildTrie(t, routeKeys)
+	trieRoot, err := trie.Commit(db)
+	require.NoError(t, err)
+
+	opened := OpenSparseMerkleTree(root, db)
+	require.Equal(t, root, opened.Hash())
+
+	for i := 0; i < 50; i++ {
+		v, ok, err := opened.TryGet(sparseKey(i))
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, []byte(fmt.Sprintf("value-%d", i)), v)
+	}
+
+	require.NoError(t, opened.Put(sparseKey(50), []byte("value-50")))
+	require.NoError(t, opened.Delete(sparseKey(0)))
+	require.NoError(t, tree.Put(sparseKey(50), []byte("value-50")))
+	require.NoError(t, tree.Delete(sparseKey(0)))
+	require.Equal(t, tree.Hash(), opened.Hash())
+
+	proof, err := opened.Prove(sparseKey(0))
+	require.NoError(t, err)
+	require.NoError(t, VerifySparseProof(opened.Hash(), sparseKey(0), proof))
+
+	v, ok, err := OpenTrie(trieRoot, db).TryGet([]byte("storage/eu"))
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, []byte("value of storage/eu"), v)
+}
@@ -1,11 +1,76 @@
 package mptrie
 
+import "io"
+
 type KVWriter interface {
 	Put([]byte, []byte) error
 	Delete([]byte) error
 }
 
 type KVReader interface {
-	Has([]byte) error
+	Has([]byte) (bool, error)
 	Get([]byte) ([]byte, error)
 }
+
+// NodeReader is the read access needed to load nodes by their hash
+type NodeReader interface {
+	Get([]byte) ([]byte, error)
+}
+
+// ValueWriter is a store that keeps the values referenced by hash apart from
+// the nodes, Commit stores values with PutValue when the store implements it
+type ValueWriter interface {
+	PutValue([]byte, []byte) error
+}
+
+// Batch collects writes in memory until Write applies all of them at once,
+// either every write is stored or none is. Reset empties the batch so it can
+// be used again.
+type Batch interface {
+	KVWriter
+	Write() error
+	Reset()
+}
+
+// Batcher is a store that can group writes in a Batch
+type Batcher interface {
+	NewBatch() Batch
+}
+
+// Iterator walks over key/value pairs in ascending key order. Next moves to
+// the following pair and reports whether there is one, Error returns the
+// error that stopped the walk early and Release frees the iterator.
+type Iterator interface {
+	Next() bool
+	Key() []byte
+	Value() []byte
+	Error() error
+	Release()
+}
+
+// Database is a key/value store that tries can be committed to and loaded
+// from. NewIterator walks over the keys starting with prefix, from the first
+// one that is not lower than prefix followed by start.
+type Database interface {
+	KVReader
+	KVWriter
+	Batcher
+	NewIterator(prefix, start []byte) Iterator
+	io.Closer
+}
+
+// writeBatch runs write against a batch of w when w supports them, so all of
+// the writes are applied at once, or against w itself otherwise.
+func writeBatch(w KVWriter, write func(KVWriter) error) error {
+	b, ok := w.(Batcher)
+	if !ok {
+		return write(w)
+	}
+
+	batch := b.NewBatch()
+	if err := write(batch); err != nil {
+		return err
+	}
+
+	return batch.Write()
+}
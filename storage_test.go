@@ -0,0 +1,137 @@
+package mptrie
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func collectIterator(t *testing.T, it Iterator) []string {
+	defer it.Release()
+
+	var keys []string
+	for it.Next() {
+		keys = append(keys, string(it.Key()))
+	}
+
+	require.NoError(t, it.Error())
+	return keys
+}
+
+func TestInMemoryStorage_Batch(t *testing.T) {
+	db := NewInMemoryStorage()
+	require.NoError(t, db.Put([]byte("a"), []byte("1")))
+
+	batch := db.NewBatch()
+	require.NoError(t, batch.Put([]byte("b"), []byte("2")))
+	require.NoError(t, batch.Delete([]byte("a")))
+
+	// nothing is visible before Write
+	has, err := db.Has([]byte("b"))
+	require.NoError(t, err)
+	require.False(t, has)
+
+	require.NoError(t, batch.Write())
+
+	has, err = db.Has([]byte("a"))
+	require.NoError(t, err)
+	require.False(t, has)
+
+	v, err := db.Get([]byte("b"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("2"), v)
+
+	batch.Reset()
+	require.NoError(t, batch.Put([]byte("c"), []byte("3")))
+	require.NoError(t, batch.Write())
+	require.Equal(t, []string{"b", "c"}, collectIterator(t, db.NewIterator(nil, nil)))
+}
+
+func TestInMemoryStorage_Iterator(t *testing.T) {
+	db := NewInMemoryStorage()
+	for _, k := range routeKeys {
+		require.NoError(t, db.Put([]byte(k), []byte("value of "+k)))
+	}
+
+	require.Equal(t, routeKeys, collectIterator(t, db.NewIterator(nil, nil)))
+	require.Equal(t, routeKeys[:5], collectIterator(t, db.NewIterator([]byte("service"), nil)))
+	require.Equal(t, routeKeys[3:5], collectIterator(t, db.NewIterator([]byte("service/"), []byte("us"))))
+	require.Empty(t, collectIterator(t, db.NewIterator([]byte("network"), nil)))
+
+	it := db.NewIterator([]byte("storage"), nil)
+	require.True(t, it.Next())
+	require.Equal(t, []byte("value of storage/eu"), it.Value())
+	require.False(t, it.Next())
+	it.Release()
+}
+
+func TestInMemoryStorage_Close(t *testing.T) {
+	db := NewInMemoryStorage()
+	require.NoError(t, db.Put([]byte("a"), []byte("1")))
+	require.NoError(t, db.Close())
+
+	_, err := db.Get([]byte("a"))
+	require.ErrorIs(t, err, NotInitialized)
+
+	_, err = db.Has([]byte("a"))
+	require.ErrorIs(t, err, NotInitialized)
+
+	require.ErrorIs(t, db.NewBatch().Write(), NotInitialized)
+	require.ErrorIs(t, db.NewIterator(nil, nil).Error(), NotInitialized)
+}
+
+// failingStorage fails the batch write after n puts, the writes made directly
+// to the storage are counted
+type failingStorage struct {
+	*InMemoryStorage
+	puts  int
+	limit int
+}
+
+func (s *failingStorage) Put(key, value []byte) error {
+	s.puts++
+	return s.InMemoryStorage.Put(key, value)
+}
+
+func (s *failingStorage) NewBatch() Batch {
+	return &failingBatch{Batch: s.InMemoryStorage.NewBatch(), limit: s.limit}
+}
+
+type failingBatch struct {
+	Batch
+	puts, limit int
+}
+
+func (b *failingBatch) Put(key, value []byte) error {
+	b.puts++
+	if b.puts > b.limit {
+		return errors.New("disk full")
+	}
+
+	return b.Batch.Put(key, value)
+}
+
+func TestCommit_ShouldBeAtomic(t *testing.T) {
+	trie := buildTrie(t, namespacedKeys)
+
+	db := &failingStorage{InMemoryStorage: NewInMemoryStorage(), limit: 3}
+	_, err := trie.Commit(db)
+	require.Error(t, err)
+	require.Zero(t, db.puts)
+	require.Empty(t, collectIterator(t, db.NewIterator(nil, nil)))
+
+	db.limit = 1 << 20
+	root, err := trie.Commit(db)
+	require.NoError(t, err)
+	require.Zero(t, db.puts)
+
+	opened := OpenTrie(root, db)
+	require.Equal(t, root, opened.Hash())
+
+	tree := buildSparseTree(t, 10)
+	db.limit = 3
+	_, err = tree.Commit(db)
+	require.Error(t, err)
+	require.Zero(t, db.puts)
+}
@@ -0,0 +1,387 @@
+package mptrie
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+)
+
+// SubstrateCodec encodes nodes with the layout of the Substrate (Polkadot)
+// base-16 trie, using SCALE instead of RLP. Its tries must be built
+// WithHasher(Blake2b) to reach the same roots as Substrate.
+//
+// Substrate has no extension nodes, a branch carries the partial key that
+// leads to it. So an extension is encoded as the branch it points to with
+// the extension path as partial key. Values referenced by hash use the
+// headers of the Substrate V1 layout.
+var SubstrateCodec NodeCodec = substrateCodec{}
+
+var (
+	ErrInvalidSubstrateNode = errors.New("invalid substrate encoded node")
+)
+
+// node headers, the first bits of the first byte, the remaining bits start
+// the size of the partial key
+const (
+	substrateEmpty                byte = 0x00
+	substrateLeaf                 byte = 0x40
+	substrateBranch               byte = 0x80
+	substrateBranchAndValue       byte = 0xc0
+	substrateLeafHashedValue      byte = 0x20
+	substrateBranchAndHashedValue byte = 0x10
+
+	substrateSizeMask             byte = 0x3f
+	substrateLeafHashedSizeMask   byte = 0x1f
+	substrateBranchHashedSizeMask byte = 0x0f
+)
+
+type substrateCodec struct{}
+
+func (c substrateCodec) Encode(n Node, ref ChildReference, value ValueReference) []byte {
+	switch node := n.(type) {
+	case nil:
+		return []byte{substrateEmpty}
+	case *LeafNode:
+		v, hashed := valueReference(node.Value, node.ValueHash, value)
+		if hashed {
+			out := encodeSubstrateHeader(substrateLeafHashedValue, substrateLeafHashedSizeMask, len(node.Path))
+			out = append(out, encodeSubstratePartial(node.Path)...)
+			return append(out, v...)
+		}
+
+		out := encodeSubstrateHeader(substrateLeaf, substrateSizeMask, len(node.Path))
+		out = append(out, encodeSubstratePartial(node.Path)...)
+		return append(out, encodeScaleBytes(v)...)
+	case *BranchNode:
+		return c.encodeBranch(nil, node, ref, value)
+	case *ExtensionNode:
+		branch, ok := node.Next.(*BranchNode)
+		if !ok {
+			panic(fmt.Sprintf("substrate codec: extension must point to a loaded branch, got %T", node.Next))
+		}
+
+		return c.encodeBranch(node.Path, branch, ref, value)
+	}
+
+	panic(fmt.Sprintf("substrate codec: cannot encode %T", n))
+}
+
+func (c substrateCodec) encodeBranch(partial []Nibble, branch *BranchNode, ref ChildReference, value ValueReference) []byte {
+	v, hashed := valueReference(branch.Value, branch.ValueHash, value)
+
+	header, mask := substrateBranch, substrateSizeMask
+	switch {
+	case hashed:
+		header, mask = substrateBranchAndHashedValue, substrateBranchHashedSizeMask
+	case branch.HasValue():
+		header = substrateBranchAndValue
+	}
+
+	out := encodeSubstrateHeader(header, mask, len(partial))
+	out = append(out, encodeSubstratePartial(partial)...)
+
+	var bitmap uint16
+	for i, child := range branch.Branches {
+		if child != nil {
+			bitmap |= 1 << uint(i)
+		}
+	}
+
+	out = append(out, byte(bitmap), byte(bitmap>>8))
+
+	switch {
+	case hashed:
+		out = append(out, v...)
+	case branch.HasValue():
+		out = append(out, encodeScaleBytes(v)...)
+	}
+
+	for _, child := range branch.Branches {
+		if child == nil {
+			continue
+		}
+
+		b, _ := ref(child)
+		out = append(out, encodeScaleBytes(b)...)
+	}
+
+	return out
+}
+
+func (c substrateCodec) Decode(b []byte) (Node, error) {
+	n, rest, err := c.decode(b)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("%w: %d trailing bytes", ErrInvalidSubstrateNode, len(rest))
+	}
+
+	return n, nil
+}
+
+func (c substrateCodec) decode(b []byte) (Node, []byte, error) {
+	if len(b) == 0 {
+		return nil, nil, fmt.Errorf("%w: empty input", ErrInvalidSubstrateNode)
+	}
+
+	var header, mask byte
+	switch {
+	case b[0] == substrateEmpty:
+		return nil, b[1:], nil
+	case b[0]&^substrateSizeMask != 0:
+		header, mask = b[0]&^substrateSizeMask, substrateSizeMask
+	case b[0]&^substrateLeafHashedSizeMask != 0:
+		header, mask = substrateLeafHashedValue, substrateLeafHashedSizeMask
+	case b[0]&^substrateBranchHashedSizeMask != 0:
+		header, mask = substrateBranchAndHashedValue, substrateBranchHashedSizeMask
+	default:
+		return nil, nil, fmt.Errorf("%w: unknown header %#x", ErrInvalidSubstrateNode, b[0])
+	}
+
+	size, b, err := decodeSubstrateSize(b, mask)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	partial, b, err := decodeSubstratePartial(b, size)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch header {
+	case substrateLeaf:
+		value, rest, err := decodeScaleBytes(b)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return NewLeafNodeFromNibbles(partial, value), rest, nil
+	case substrateLeafHashedValue:
+		hash, rest, err := decodeSubstrateHash(b)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		leaf := NewLeafNodeFromNibbles(partial, nil)
+		leaf.ValueHash = hash
+		return leaf, rest, nil
+	}
+
+	if len(b) < 2 {
+		return nil, nil, fmt.Errorf("%w: missing children bitmap", ErrInvalidSubstrateNode)
+	}
+
+	bitmap := binary.LittleEndian.Uint16(b)
+	b = b[2:]
+
+	branch := NewBranchNode()
+	switch header {
+	case substrateBranchAndValue:
+		branch.Value, b, err = decodeScaleBytes(b)
+	case substrateBranchAndHashedValue:
+		branch.ValueHash, b, err = decodeSubstrateHash(b)
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i := 0; i < 16; i++ {
+		if bitmap&(1<<uint(i)) == 0 {
+			continue
+		}
+
+		var ref []byte
+		ref, b, err = decodeScaleBytes(b)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if len(ref) == substrateHashSize {
+			branch.SetBranch(Nibble(i), HashNode(ref))
+			continue
+		}
+
+		child, rest, err := c.decode(ref)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if len(rest) > 0 || child == nil {
+			return nil, nil, fmt.Errorf("%w: invalid embedded child", ErrInvalidSubstrateNode)
+		}
+
+		branch.SetBranch(Nibble(i), child)
+	}
+
+	if len(partial) == 0 {
+		return branch, b, nil
+	}
+
+	return NewExtensionNode(partial, branch), b, nil
+}
+
+// substrateHashSize is the size of the hashes referencing children and values
+const substrateHashSize = 32
+
+func decodeSubstrateHash(b []byte) ([]byte, []byte, error) {
+	if len(b) < substrateHashSize {
+		return nil, nil, fmt.Errorf("%w: truncated value hash", ErrInvalidSubstrateNode)
+	}
+
+	return copyBytes(b[:substrateHashSize]), b[substrateHashSize:], nil
+}
+
+// encodeSubstrateHeader writes the node kind and the number of nibbles in
+// its partial key, sizes that do not fit in the bits of mask continue in the
+// next bytes.
+func encodeSubstrateHeader(kind, mask byte, size int) []byte {
+	if size < int(mask) {
+		return []byte{kind | byte(size)}
+	}
+
+	out := []byte{kind | mask}
+	size -= int(mask)
+
+	for {
+		if size < 255 {
+			return append(out, byte(size))
+		}
+
+		out = append(out, 255)
+		size -= 255
+	}
+}
+
+func decodeSubstrateSize(b []byte, mask byte) (int, []byte, error) {
+	size := int(b[0] & mask)
+	b = b[1:]
+
+	if size < int(mask) {
+		return size, b, nil
+	}
+
+	for {
+		if len(b) == 0 {
+			return 0, nil, fmt.Errorf("%w: truncated header", ErrInvalidSubstrateNode)
+		}
+
+		next := b[0]
+		b = b[1:]
+		size += int(next)
+
+		if next < 255 {
+			return size, b, nil
+		}
+	}
+}
+
+// encodeSubstratePartial packs the nibbles two per byte, when there is an odd
+// number of them the first one is alone in the first byte
+func encodeSubstratePartial(ns []Nibble) []byte {
+	if len(ns)%2 == 1 {
+		return append([]byte{byte(ns[0])}, ToBytes(ns[1:])...)
+	}
+
+	return ToBytes(ns)
+}
+
+func decodeSubstratePartial(b []byte, size int) ([]Nibble, []byte, error) {
+	length := (size + 1) / 2
+	if len(b) < length {
+		return nil, nil, fmt.Errorf("%w: truncated partial key", ErrInvalidSubstrateNode)
+	}
+
+	ns := FromBytes(b[:length])
+	if size%2 == 1 {
+		if ns[0] != 0 {
+			return nil, nil, fmt.Errorf("%w: invalid partial key padding", ErrInvalidSubstrateNode)
+		}
+
+		ns = ns[1:]
+	}
+
+	return ns, b[length:], nil
+}
+
+// encodeScaleBytes encodes b as a SCALE byte vector, its compact length
+// followed by its content
+func encodeScaleBytes(b []byte) []byte {
+	return append(encodeScaleCompact(uint64(len(b))), b...)
+}
+
+func decodeScaleBytes(b []byte) ([]byte, []byte, error) {
+	length, b, err := decodeScaleCompact(b)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if uint64(len(b)) < length {
+		return nil, nil, fmt.Errorf("%w: truncated byte vector", ErrInvalidSubstrateNode)
+	}
+
+	return copyBytes(b[:length]), b[length:], nil
+}
+
+// encodeScaleCompact encodes n with the SCALE compact integer format, the 2
+// lowest bits of the first byte tell how many bytes are used
+func encodeScaleCompact(n uint64) []byte {
+	switch {
+	case n < 1<<6:
+		return []byte{byte(n << 2)}
+	case n < 1<<14:
+		return []byte{byte(n<<2) | 0x01, byte(n >> 6)}
+	case n < 1<<30:
+		out := make([]byte, 4)
+		binary.LittleEndian.PutUint32(out, uint32(n<<2)|0x02)
+		return out
+	}
+
+	size := (bits.Len64(n) + 7) / 8
+	out := []byte{byte(size-4)<<2 | 0x03}
+	for i := 0; i < size; i++ {
+		out = append(out, byte(n>>(8*uint(i))))
+	}
+
+	return out
+}
+
+func decodeScaleCompact(b []byte) (uint64, []byte, error) {
+	if len(b) == 0 {
+		return 0, nil, fmt.Errorf("%w: missing compact integer", ErrInvalidSubstrateNode)
+	}
+
+	switch b[0] & 0x03 {
+	case 0x00:
+		return uint64(b[0] >> 2), b[1:], nil
+	case 0x01:
+		if len(b) < 2 {
+			break
+		}
+
+		return uint64(binary.LittleEndian.Uint16(b) >> 2), b[2:], nil
+	case 0x02:
+		if len(b) < 4 {
+			break
+		}
+
+		return uint64(binary.LittleEndian.Uint32(b) >> 2), b[4:], nil
+	default:
+		size := int(b[0]>>2) + 4
+		if size > 8 || len(b) < size+1 {
+			break
+		}
+
+		var n uint64
+		for i := 0; i < size; i++ {
+			n |= uint64(b[1+i]) << (8 * uint(i))
+		}
+
+		return n, b[size+1:], nil
+	}
+
+	return 0, nil, fmt.Errorf("%w: truncated compact integer", ErrInvalidSubstrateNode)
+}
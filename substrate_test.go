@@ -0,0 +1,248 @@
+package mptrie
+
+import (
+	"compress/gzip"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// substrateVector is an entry of testdata/substrate/vectors.json, see
+// testdata/substrate/README.md for where the vectors come from
+type substrateVector struct {
+	Name    string `json:"name"`
+	Entries []struct {
+		Key   string `json:"key"`
+		Value string `json:"value"`
+	} `json:"entries"`
+	Encoded string `json:"encoded"`
+	Root    string `json:"root"`
+}
+
+func loadSubstrateVectors(t *testing.T) []substrateVector {
+	b, err := os.ReadFile("testdata/substrate/vectors.json")
+	require.NoError(t, err)
+
+	var vectors []substrateVector
+	require.NoError(t, json.Unmarshal(b, &vectors))
+	require.NotEmpty(t, vectors)
+
+	return vectors
+}
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	require.NoError(t, err)
+	return b
+}
+
+func newSubstrateTrie() *Trie {
+	return NewTrie(WithCodec(SubstrateCodec), WithHasher(Blake2b))
+}
+
+func TestSubstrateCodec_ShouldMatchSubstrateVectors(t *testing.T) {
+	for _, v := range loadSubstrateVectors(t) {
+		trie := newSubstrateTrie()
+		for _, e := range v.Entries {
+			require.NoError(t, trie.Put(mustDecodeHex(t, e.Key), mustDecodeHex(t, e.Value)), v.Name)
+		}
+
+		encoded := SubstrateCodec.Encode(trie.root, trie.encoder().reference, nil)
+		require.Equal(t, v.Encoded, hex.EncodeToString(encoded), v.Name)
+		require.Equal(t, v.Root, hex.EncodeToString(trie.Hash()), v.Name)
+
+		decoded, err := SubstrateCodec.Decode(encoded)
+		require.NoError(t, err, v.Name)
+		require.Equal(t, encoded, SubstrateCodec.Encode(decoded, trie.encoder().reference, nil), v.Name)
+	}
+}
+
+// westendGenesisHash is the hash of the Westend genesis header, it commits to
+// the state root of the genesis entries
+const westendGenesisHash = "e143f23803ac50e8f6f8e62695d1ce9e4e1d68aa36c1cd2cfd15340213f3423e"
+
+func TestSubstrateCodec_ShouldMatchWestendGenesis(t *testing.T) {
+	f, err := os.Open("testdata/substrate/westend-chain-spec-raw.json.gz")
+	require.NoError(t, err)
+	defer f.Close()
+
+	r, err := gzip.NewReader(f)
+	require.NoError(t, err)
+
+	var spec struct {
+		Genesis struct {
+			Raw struct {
+				Top map[string]string `json:"top"`
+			} `json:"raw"`
+		} `json:"genesis"`
+	}
+	require.NoError(t, json.NewDecoder(r).Decode(&spec))
+	require.Len(t, spec.Genesis.Raw.Top, 93)
+
+	trie := newSubstrateTrie()
+	for k, v := range spec.Genesis.Raw.Top {
+		require.NoError(t, trie.Put(mustDecodeHex(t, strings.TrimPrefix(k, "0x")), mustDecodeHex(t, strings.TrimPrefix(v, "0x"))))
+	}
+
+	root := trie.Hash()
+	require.Equal(t, "7e92439a94f79671f9cade9dff96a094519b9001a7432244d46ab644bb6f746f", hex.EncodeToString(root))
+
+	// the genesis header: zero parent hash, block number 0, state root,
+	// extrinsics root of no extrinsics and an empty digest
+	header := append(make([]byte, 32), encodeScaleCompact(0)...)
+	header = append(header, root...)
+	header = append(header, Blake2b.Hash([]byte{substrateEmpty})...)
+	header = append(header, encodeScaleCompact(0)...)
+	require.Equal(t, westendGenesisHash, hex.EncodeToString(Blake2b.Hash(header)))
+
+	// the shapes the genesis is kept for
+	db := nodeSet{}
+	_, err = trie.Commit(db)
+	require.NoError(t, err)
+
+	var hashedChildren, partialBranches, longPartials int
+	for _, blob := range db {
+		n, err := SubstrateCodec.Decode(blob)
+		require.NoError(t, err)
+
+		branch, _ := n.(*BranchNode)
+		switch node := n.(type) {
+		case *LeafNode:
+			if len(node.Path) >= int(substrateSizeMask) {
+				longPartials++
+			}
+		case *ExtensionNode:
+			partialBranches++
+			if len(node.Path) >= int(substrateSizeMask) {
+				longPartials++
+			}
+
+			branch = node.Next.(*BranchNode)
+		}
+
+		if branch == nil {
+			continue
+		}
+
+		for _, child := range branch.Branches {
+			if _, ok := child.(HashNode); ok {
+				hashedChildren++
+			}
+		}
+	}
+
+	require.NotZero(t, hashedChildren)
+	require.NotZero(t, partialBranches)
+	require.NotZero(t, longPartials)
+}
+
+func TestSubstrateCodec_ShouldVerifySubstrateProofs(t *testing.T) {
+	b, err := os.ReadFile("testdata/substrate/proofs.json")
+	require.NoError(t, err)
+
+	var vectors []struct {
+		Name  string   `json:"name"`
+		Root  string   `json:"root"`
+		Key   string   `json:"key"`
+		Value string   `json:"value"`
+		Proof []string `json:"proof"`
+	}
+	require.NoError(t, json.Unmarshal(b, &vectors))
+	require.NotEmpty(t, vectors)
+
+	e := newSubstrateTrie().encoder()
+	for _, v := range vectors {
+		db := nodeSet{}
+		for _, item := range v.Proof {
+			blob := mustDecodeHex(t, item)
+			db[string(Blake2b.Hash(blob))] = blob
+
+			// values stored by hash are proof items too, they are not nodes
+			if item == v.Value {
+				continue
+			}
+
+			n, err := SubstrateCodec.Decode(blob)
+			require.NoError(t, err, v.Name)
+			require.Equal(t, blob, SubstrateCodec.Encode(n, e.reference, nil), v.Name)
+		}
+
+		trie := OpenTrie(mustDecodeHex(t, v.Root), db, WithCodec(SubstrateCodec), WithHasher(Blake2b))
+		value, ok, err := trie.TryGet(mustDecodeHex(t, v.Key))
+		require.NoError(t, err, v.Name)
+		require.True(t, ok, v.Name)
+		require.Equal(t, v.Value, hex.EncodeToString(value), v.Name)
+	}
+}
+
+func TestSubstrateCodec_ShouldCommitAndOpen(t *testing.T) {
+	trie := newSubstrateTrie()
+	for _, k := range namespacedKeys {
+		// values long enough for the nodes to be stored by hash
+		require.NoError(t, trie.Put([]byte(k), []byte(fmt.Sprintf("value of %s with padding to 32 bytes", k))))
+	}
+
+	db := NewInMemoryStorage()
+	root, err := trie.Commit(db)
+	require.NoError(t, err)
+	require.Equal(t, trie.Hash(), root)
+
+	opened := OpenTrie(root, db, WithCodec(SubstrateCodec), WithHasher(Blake2b))
+	require.Equal(t, root, opened.Hash())
+
+	for _, k := range namespacedKeys {
+		v, ok, err := opened.TryGet([]byte(k))
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, []byte(fmt.Sprintf("value of %s with padding to 32 bytes", k)), v)
+	}
+
+	require.NoError(t, opened.Delete([]byte(namespacedKeys[0])))
+	require.NoError(t, trie.Delete([]byte(namespacedKeys[0])))
+	require.Equal(t, trie.Hash(), opened.Hash())
+}
+
+func TestSubstrateCodec_ShouldEncodeLongPartialKeys(t *testing.T) {
+	for _, size := range []int{0, 1, 62, 63, 64, 317, 318, 1000} {
+		path := make([]Nibble, size)
+		for i := range path {
+			path[i] = Nibble(i % 16)
+		}
+
+		leaf := NewLeafNodeFromNibbles(path, []byte{0x01})
+		encoded := SubstrateCodec.Encode(leaf, nil, nil)
+
+		decoded, err := SubstrateCodec.Decode(encoded)
+		require.NoError(t, err)
+		require.Equal(t, path, decoded.(*LeafNode).Path, "size %d", size)
+	}
+}
+
+func TestSubstrateCodec_ShouldRoundTripCompactIntegers(t *testing.T) {
+	for _, n := range []uint64{0, 1, 63, 64, 16383, 16384, 1<<30 - 1, 1 << 30, 1<<64 - 1} {
+		encoded := encodeScaleCompact(n)
+
+		decoded, rest, err := decodeScaleCompact(encoded)
+		require.NoError(t, err)
+		require.Empty(t, rest)
+		require.Equal(t, n, decoded)
+	}
+
+	// examples from the SCALE specification
+	require.Equal(t, []byte{0x04}, encodeScaleCompact(1))
+	require.Equal(t, []byte{0x15, 0x01}, encodeScaleCompact(69))
+	require.Equal(t, []byte{0xfe, 0xff, 0x03, 0x00}, encodeScaleCompact(65535))
+	require.Equal(t, []byte{0x03, 0x00, 0x00, 0x00, 0x40}, encodeScaleCompact(1<<30))
+}
+
+func TestSubstrateCodec_ShouldRejectMalformedNodes(t *testing.T) {
+	for _, b := range [][]byte{{}, {0x01}, {0x42, 0xaa}, {0x80, 0x01}, {0x80, 0x01, 0x00, 0x08, 0x00}, {0x00, 0x00}} {
+		_, err := SubstrateCodec.Decode(b)
+		require.ErrorIs(t, err, ErrInvalidSubstrateNode, "%x", b)
+	}
+}
@@ -0,0 +1,172 @@
+package mptrie
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+var (
+	ErrIncompleteWitness = errors.New("witness misses a node needed by the changes")
+)
+
+// TransitionProof shows that applying Changes to the trie with root PreRoot
+// gives the trie with root PostRoot. Witness holds every node of the pre
+// state, and every value stored apart, that applying the changes loads.
+type TransitionProof struct {
+	ChangeSet
+	Witness [][]byte
+}
+
+// CreateTransitionProof builds the proof of applying changes to t, t itself
+// is not changed. The changes are applied to a copy of t loaded from its
+// committed form, recording each node read, so the verifier loads exactly
+// the same nodes.
+func CreateTransitionProof(t *Trie, changes []Change) (*TransitionProof, error) {
+	pre := t.Copy()
+	pre.values = nil
+
+	// the nodes only in memory are read from their committed form
+	overlay := make(nodeSet)
+	root, err := pre.Commit(overlay)
+	if err != nil {
+		return nil, err
+	}
+
+	recorder := &witnessRecorder{nodes: make(map[string][]byte)}
+	recorder.readers = append(recorder.readers, overlay)
+	if t.values != nil {
+		recorder.readers = append(recorder.readers, t.values)
+	}
+
+	if t.db != nil {
+		recorder.readers = append(recorder.readers, t.db)
+	}
+
+	replay := t.Copy()
+	replay.db, replay.values, replay.cache, replay.counted = recorder, nil, nil, false
+	replay.root = nil
+	if !bytes.Equal(root, replay.encoder().emptyRoot()) {
+		replay.root = HashNode(root)
+	}
+
+	if err := applyChanges(replay, changes); err != nil {
+		return nil, err
+	}
+
+	proof := &TransitionProof{
+		ChangeSet: ChangeSet{PreRoot: root, PostRoot: replay.Hash(), Changes: changes},
+	}
+
+	for _, hash := range sortedMapKeys(recorder.nodes) {
+		proof.Witness = append(proof.Witness, recorder.nodes[hash])
+	}
+
+	return proof, nil
+}
+
+// Verify rebuilds the part of the pre state held by the witness, applies the
+// changes to it and checks that the result has root PostRoot. The caller
+// checks that PreRoot and PostRoot are the roots it expects, opts must match
+// the ones of the trie the proof was built from.
+func (p *TransitionProof) Verify(opts ...Option) error {
+	e := NewTrie(opts...).encoder()
+
+	// nodes are keyed by their own hash, so a node that does not belong to
+	// the pre state is never reached
+	witness := make(nodeSet, len(p.Witness))
+	for _, node := range p.Witness {
+		witness[string(e.hasher.Hash(node))] = node
+	}
+
+	t := OpenTrie(p.PreRoot, &witnessReader{nodes: witness}, opts...)
+	return p.Apply(t)
+}
+
+// witnessRecorder keeps every node read from the first of readers holding it
+type witnessRecorder struct {
+	readers []NodeReader
+	nodes   map[string][]byte
+}
+
+func (r *witnessRecorder) Get(key []byte) ([]byte, error) {
+	for _, reader := range r.readers {
+		value, err := reader.Get(key)
+		if errors.Is(err, KeyNotFound) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		r.nodes[string(key)] = value
+		return value, nil
+	}
+
+	return nil, KeyNotFound
+}
+
+type witnessReader struct {
+	nodes nodeSet
+}
+
+func (r *witnessReader) Get(key []byte) ([]byte, error) {
+	node, ok := r.nodes[string(key)]
+	if !ok {
+		return nil, ErrIncompleteWitness
+	}
+
+	return copyBytes(node), nil
+}
+
+func (s nodeSet) Get(key []byte) ([]byte, error) {
+	value, ok := s[string(key)]
+	if !ok {
+		return nil, KeyNotFound
+	}
+
+	return copyBytes(value), nil
+}
+
+func sortedMapKeys(m map[string][]byte) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+type encodedTransitionProof struct {
+	ChangeSet []byte
+	Witness   [][]byte
+}
+
+// Encode returns the binary (RLP) form of the proof
+func (p *TransitionProof) Encode() ([]byte, error) {
+	cs, err := p.ChangeSet.Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	return rlp.EncodeToBytes(encodedTransitionProof{ChangeSet: cs, Witness: p.Witness})
+}
+
+// DecodeTransitionProof rebuilds a proof from the output of Encode
+func DecodeTransitionProof(b []byte) (*TransitionProof, error) {
+	var enc encodedTransitionProof
+	if err := rlp.DecodeBytes(b, &enc); err != nil {
+		return nil, err
+	}
+
+	cs, err := DecodeChangeSet(enc.ChangeSet)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TransitionProof{ChangeSet: *cs, Witness: enc.Witness}, nil
+}
@@ -0,0 +1,132 @@
+package mptrie
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransitionProof_ShouldTakePreRootToPostRoot(t *testing.T) {
+	trie := buildTrie(t, namespacedKeys)
+	before := trie.Hash()
+
+	changes := []Change{
+		{Kind: Modified, Key: []byte("accounts.balance"), To: []byte("100")},
+		{Kind: Added, Key: []byte("block.parent"), To: []byte("0x01")},
+		{Kind: Removed, Key: []byte("transfer.input.value")},
+		{Kind: Removed, Key: []byte("block.header")},
+		{Kind: Removed, Key: []byte("missing")},
+	}
+
+	proof, err := CreateTransitionProof(trie, changes)
+	require.NoError(t, err)
+	require.Equal(t, before, trie.Hash())
+	require.Equal(t, before, proof.PreRoot)
+
+	expected := trie.Copy()
+	require.NoError(t, applyChanges(expected, changes))
+	require.Equal(t, expected.Hash(), proof.PostRoot)
+
+	require.NoError(t, proof.Verify())
+
+	encoded, err := proof.Encode()
+	require.NoError(t, err)
+
+	decoded, err := DecodeTransitionProof(encoded)
+	require.NoError(t, err)
+	require.NoError(t, decoded.Verify())
+}
+
+func TestTransitionProof_ShouldOnlyHoldTouchedNodes(t *testing.T) {
+	db := NewInMemoryStorage()
+	trie, roots := commitVersions(t, db, 3)
+	opened := OpenTrie(roots[2], db)
+
+	changes := []Change{
+		{Kind: Modified, Key: []byte("account-042"), To: []byte("changed")},
+		{Kind: Removed, Key: []byte("account-043")},
+	}
+
+	proof, err := CreateTransitionProof(opened, changes)
+	require.NoError(t, err)
+	require.NoError(t, proof.Verify())
+
+	nodes := 0
+	it := db.NewIterator(nil, nil)
+	for it.Next() {
+		nodes++
+	}
+
+	it.Release()
+	require.Less(t, len(proof.Witness), nodes/10)
+
+	expected := trie.Copy()
+	require.NoError(t, applyChanges(expected, changes))
+	require.Equal(t, expected.Hash(), proof.PostRoot)
+}
+
+func TestTransitionProof_ShouldRejectTamperedProofs(t *testing.T) {
+	trie := buildTrie(t, namespacedKeys)
+	changes := []Change{
+		{Kind: Modified, Key: []byte("accounts.nonce"), To: []byte("2")},
+		{Kind: Removed, Key: []byte("transfer.to")},
+	}
+
+	proof, err := CreateTransitionProof(trie, changes)
+	require.NoError(t, err)
+
+	tampered := *proof
+	tampered.PostRoot = trie.Hash()
+	require.ErrorIs(t, tampered.Verify(), ErrPostRootMismatch)
+
+	tampered = *proof
+	tampered.Changes = []Change{changes[0], {Kind: Modified, Key: []byte("transfer.to"), To: []byte("x")}}
+	require.ErrorIs(t, tampered.Verify(), ErrPostRootMismatch)
+
+	for i := range proof.Witness {
+		tampered = *proof
+		tampered.Witness = append(append([][]byte{}, proof.Witness[:i]...), proof.Witness[i+1:]...)
+		require.ErrorIs(t, tampered.Verify(), ErrIncompleteWitness)
+
+		tampered.Witness = append(tampered.Witness, append([]byte{0}, proof.Witness[i]...))
+		require.ErrorIs(t, tampered.Verify(), ErrIncompleteWitness)
+	}
+}
+
+func TestTransitionProof_ShouldMatchRandomChanges(t *testing.T) {
+	r := rand.New(rand.NewSource(7))
+
+	for round := 0; round < 20; round++ {
+		opts := []Option{}
+		if round%2 == 1 {
+			opts = append(opts, WithHashedValues(33, nil))
+		}
+
+		trie := NewTrie(opts...)
+		for i := 0; i < 200; i++ {
+			value := bytes.Repeat([]byte{byte(i)}, 1+r.Intn(60))
+			require.NoError(t, trie.Put([]byte(fmt.Sprintf("key-%d", r.Intn(500))), value))
+		}
+
+		var changes []Change
+		for i := 0; i < 1+r.Intn(30); i++ {
+			key := []byte(fmt.Sprintf("key-%d", r.Intn(500)))
+			if r.Intn(2) == 0 {
+				changes = append(changes, Change{Kind: Removed, Key: key})
+			} else {
+				changes = append(changes, Change{Kind: Modified, Key: key, To: bytes.Repeat([]byte{0xee}, 1+r.Intn(60))})
+			}
+		}
+
+		proof, err := CreateTransitionProof(trie, changes)
+		require.NoError(t, err)
+		require.NoError(t, proof.Verify(opts...), "round %d", round)
+
+		expected := trie.Copy()
+		require.NoError(t, applyChanges(expected, changes))
+		require.Equal(t, expected.Hash(), proof.PostRoot)
+	}
+}
@@ -6,41 +6,122 @@ import (
 
 type Trie struct {
 	root Node
+
+	// db is where nodes referenced by hash are loaded from, cache keeps the
+	// ones already decoded
+	db     NodeReader
+	cache  *NodeCache
+	hasher Hasher
+	codec  NodeCodec
+
+	// inline and hashedValues are the sizes from which nodes and values are
+	// referenced by hash, values referenced by hash are kept in values
+	inline       int
+	hashedValues int
+	values       ValueStore
+
+	// counted keeps the number of keys below every branch and extension
+	// node, commitCounts also adds those numbers to the node hashes
+	counted      bool
+	commitCounts bool
+
+	// snapshot answers reads while the root is the one of the snapshot
+	snapshot Snapshot
 }
 
-func NewTrie() *Trie {
-	return new(Trie)
+// Option changes how a trie is built
+type Option func(*Trie)
+
+func NewTrie(opts ...Option) *Trie {
+	t := &Trie{hasher: Keccak256, codec: RLPCodec}
+
+	for _, opt := range opts {
+		opt(t)
+	}
+
+	return t
 }
 
 func (t *Trie) Hash() []byte {
-	if t.root == nil {
-		return EmptyNodeHash
+	return t.encoder().hash(t.root)
+}
+
+// encoder returns how the trie serializes and hashes its nodes
+func (t Trie) encoder() encoder {
+	e := encoder{hasher: t.hasher, codec: t.codec, inline: t.inline, hashedValues: t.hashedValues}
+	if e.inline == 0 {
+		e.inline = 32
+	}
+
+	if e.hasher == nil {
+		e.hasher = Keccak256
+	}
+
+	if e.codec == nil {
+		e.codec = RLPCodec
 	}
 
-	return t.root.Hash()
+	// only the RLP layout has room for the counts
+	if c, ok := e.codec.(rlpCodec); ok && t.commitCounts {
+		c.counts = true
+		e.codec = c
+	}
+
+	return e
+}
+
+// Copy returns a trie with the same content that can be changed on its own,
+// both tries share every node until one of them changes it.
+func (t *Trie) Copy() *Trie {
+	c := *t
+	return &c
 }
 
+// Get returns the value stored under key, for tries opened from storage a node
+// that cannot be loaded is reported as a missing key, use TryGet to tell them
+// apart.
 func (t Trie) Get(key []byte) ([]byte, bool) {
+	value, ok, err := t.TryGet(key)
+	if err != nil {
+		return nil, false
+	}
+
+	return value, ok
+}
+
+// TryGet returns the value stored under key or the error found while loading
+// the nodes along its path.
+func (t Trie) TryGet(key []byte) ([]byte, bool, error) {
+	if value, ok, served, err := t.snapshotGet(key); served {
+		return value, ok, err
+	}
+
 	node := t.root
 	nibbles := FromBytes(key)
 
+	var err error
 	for {
+		node, err = t.resolve(node)
+		if err != nil {
+			return nil, false, err
+		}
+
 		if node == nil {
-			return nil, false
+			return nil, false, nil
 		}
 
 		if leaf, ok := node.(*LeafNode); ok {
 			matched := PrefixMatchedLen(nibbles, leaf.Path)
 			if matched != len(nibbles) || matched != len(leaf.Path) {
-				return nil, false
+				return nil, false, nil
 			}
 
-			return leaf.Value, true
+			return leaf.Value, true, nil
 		}
 
 		if branch, ok := node.(*BranchNode); ok {
 			if len(nibbles) == 0 {
-				return branch.Value, branch.HasValue()
+				return branch.Value, branch.HasValue(), nil
 			}
 
 			b, remaining := nibbles[0], nibbles[1:]
@@ -54,7 +135,7 @@ func (t Trie) Get(key []byte) ([]byte, bool) {
 			matched := PrefixMatchedLen(ext.Path, nibbles)
 
 			if matched < len(ext.Path) {
-				return nil, false
+				return nil, false, nil
 			}
 
 			nibbles = nibbles[matched:]
@@ -62,7 +143,7 @@ func (t Trie) Get(key []byte) ([]byte, bool) {
 			continue
 		}
 
-		return nil, false
+		return nil, false, nil
 	}
 }
 
@@ -71,6 +152,18 @@ func (t Trie) Get(key []byte) ([]byte, bool) {
 // LeafNode      -> transform into a Extension Node add a new branch node and a new leaf node
 // ExtensionNode -> convert to a Extension Node with a shorter path, create a branch node that points to a new Extension Node
 func (t *Trie) Put(key, value []byte) error {
+	if err := t.put(key, value); err != nil {
+		return err
+	}
+
+	if t.counted {
+		return t.refreshCounts(FromBytes(key))
+	}
+
+	return nil
+}
+
+func (t *Trie) put(key, value []byte) error {
 	node := &t.root
 	nibbles := FromBytes(key)
 
@@ -79,6 +172,13 @@ func (t *Trie) Put(key, value []byte) error {
 	}
 
 	for {
+		resolved, err := t.resolve(*node)
+		if err != nil {
+			return err
+		}
+
+		*node = resolved
+
 		if *node == nil {
 			leaf := NewLeafNodeFromNibbles(nibbles, value)
 			*node = leaf
@@ -130,7 +230,18 @@ func (t *Trie) Put(key, value []byte) error {
 			return nil
 		}
 
+		// nodes along the path are copied before changing them, so tries
+		// sharing nodes never see each other changes
 		if branch, ok := (*node).(*BranchNode); ok {
+			branch = branch.clone()
+			*node = branch
+
+			// the key ends exactly at this branch, so the value lives in it
+			if len(nibbles) == 0 {
+				branch.SetValue(value)
+				return nil
+			}
+
 			branchNibble, remaining := nibbles[0], nibbles[1:]
 			nibbles = remaining
 			node = &branch.Branches[int(branchNibble)]
@@ -143,7 +254,6 @@ func (t *Trie) Put(key, value []byte) error {
 
 			if matched < len(ext.Path) {
 				extNibbles, branchNibble, extRemaining := ext.Path[:matched], ext.Path[matched], ext.Path[matched+1:]
-				newBranchNibble, newLeafNibbles := nibbles[matched], nibbles[matched+1:]
 
 				branch := NewBranchNode()
 				if len(extRemaining) == 0 {
@@ -153,8 +263,13 @@ func (t *Trie) Put(key, value []byte) error {
 					branch.SetBranch(branchNibble, newExt)
 				}
 
-				newleaf := NewLeafNodeFromNibbles(newLeafNibbles, value)
-				branch.SetBranch(newBranchNibble, newleaf)
+				if matched < len(nibbles) {
+					newBranchNibble, newLeafNibbles := nibbles[matched], nibbles[matched+1:]
+					newleaf := NewLeafNodeFromNibbles(newLeafNibbles, value)
+					branch.SetBranch(newBranchNibble, newleaf)
+				} else {
+					branch.SetValue(value)
+				}
 
 				if len(extNibbles) == 0 {
 					*node = branch
@@ -164,6 +279,10 @@ func (t *Trie) Put(key, value []byte) error {
 
 				return nil
 			}
+
+			ext = ext.clone()
+			*node = ext
+
 			nibbles = nibbles[matched:]
 			node = &ext.Next
 			continue
@@ -0,0 +1,198 @@
+package mptrie
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+type ProblemKind int
+
+const (
+	HashMismatch ProblemKind = iota
+	MissingNode
+	UndecodableNode
+	ShortBranch
+	ConsecutiveExtensions
+	EmptyExtension
+	LeafNotAtKeyEnd
+)
+
+func (k ProblemKind) String() string {
+	switch k {
+	case HashMismatch:
+		return "hash mismatch"
+	case MissingNode:
+		return "missing node"
+	case UndecodableNode:
+		return "undecodable node"
+	case ShortBranch:
+		return "branch with fewer than two entries"
+	case ConsecutiveExtensions:
+		return "extension following an extension"
+	case EmptyExtension:
+		return "extension with an empty path"
+	case LeafNotAtKeyEnd:
+		return "leaf path does not end a key"
+	}
+
+	return "unknown"
+}
+
+// Problem is something wrong found in the node at Path, Hash is the hash the
+// node, or value, is referenced by when it is stored on its own.
+type Problem struct {
+	Kind ProblemKind
+	Path []Nibble
+	Hash []byte
+}
+
+// String shows the path with a hex digit per nibble
+func (p Problem) String() string {
+	path := make([]byte, len(p.Path))
+	for i, n := range p.Path {
+		path[i] = "0123456789abcdef"[n]
+	}
+
+	return fmt.Sprintf("%s at path %q", p.Kind, path)
+}
+
+// ValidationReport lists every problem found by Validate in walk order,
+// Nodes is the number of nodes checked.
+type ValidationReport struct {
+	Nodes    int
+	Problems []Problem
+}
+
+// Valid reports whether no problem was found
+func (r *ValidationReport) Valid() bool {
+	return len(r.Problems) == 0
+}
+
+// Validate walks every node reachable from root in db, checking that each
+// stored node and value matches the hash it is stored under and that the
+// trie has the shape Put and Delete would give it. The walk goes on after a
+// problem, skipping what is below a node that does not match its hash, only
+// errors from db stop it. opts must match the ones the trie
+// was built with.
+func Validate(root []byte, db KVReader, opts ...Option) (*ValidationReport, error) {
+	e := NewTrie(opts...).encoder()
+	report := &ValidationReport{}
+
+	if len(root) == 0 || bytes.Equal(root, e.emptyRoot()) {
+		return report, nil
+	}
+
+	type entry struct {
+		node Node
+		path []Nibble
+		// afterExtension is set for the node an extension points to
+		afterExtension bool
+	}
+
+	problem := func(kind ProblemKind, path []Nibble, hash []byte) {
+		report.Problems = append(report.Problems, Problem{Kind: kind, Path: path, Hash: hash})
+	}
+
+	// load returns the blob stored under hash, or nil after reporting why
+	// it cannot be used
+	load := func(hash []byte, path []Nibble) ([]byte, error) {
+		blob, err := db.Get(hash)
+		if errors.Is(err, KeyNotFound) {
+			problem(MissingNode, path, hash)
+			return nil, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		// a blob stored under another hash is not the node referenced, what
+		// it points to is not part of the trie
+		if !bytes.Equal(e.hasher.Hash(blob), hash) {
+			problem(HashMismatch, path, hash)
+			return nil, nil
+		}
+
+		return blob, nil
+	}
+
+	// visited keeps a node referenced twice from being checked twice
+	visited := make(map[string]bool)
+
+	pending := []entry{{node: HashNode(root)}}
+	for len(pending) > 0 {
+		next := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		if hash, ok := next.node.(HashNode); ok {
+			if visited[string(hash)] {
+				continue
+			}
+
+			visited[string(hash)] = true
+
+			blob, err := load(hash, next.path)
+			if err != nil {
+				return nil, err
+			}
+
+			if blob == nil {
+				continue
+			}
+
+			n, err := e.decode(blob)
+			if err != nil {
+				problem(UndecodableNode, next.path, hash)
+				continue
+			}
+
+			next.node = n
+		}
+
+		report.Nodes++
+
+		var valueHash []byte
+		switch node := next.node.(type) {
+		case *LeafNode:
+			if (len(next.path)+len(node.Path))%2 != 0 {
+				problem(LeafNotAtKeyEnd, next.path, nil)
+			}
+
+			valueHash = node.ValueHash
+		case *BranchNode:
+			children := 0
+			for i := len(node.Branches) - 1; i >= 0; i-- {
+				if child := node.Branches[i]; child != nil {
+					children++
+					pending = append(pending, entry{node: child, path: ConcatNibbles(next.path, []Nibble{Nibble(i)})})
+				}
+			}
+
+			// a value alone belongs in a leaf
+			if children == 0 || children < 2 && !node.HasValue() {
+				problem(ShortBranch, next.path, nil)
+			}
+
+			valueHash = node.ValueHash
+		case *ExtensionNode:
+			if len(node.Path) == 0 {
+				problem(EmptyExtension, next.path, nil)
+			}
+
+			if next.afterExtension {
+				problem(ConsecutiveExtensions, next.path, nil)
+			}
+
+			pending = append(pending, entry{node: node.Next, path: ConcatNibbles(next.path, node.Path), afterExtension: true})
+		}
+
+		if valueHash != nil {
+			if _, err := load(valueHash, next.path); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return report, nil
+}
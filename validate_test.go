@@ -0,0 +1,164 @@
+package mptrie
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func storeRoot(t *testing.T, root Node, db KVWriter) []byte {
+	hash, err := defaultEncoder.commitRoot(root, db, db)
+	require.NoError(t, err)
+	return hash
+}
+
+func problemKinds(report *ValidationReport) map[ProblemKind][]Nibble {
+	kinds := make(map[ProblemKind][]Nibble)
+	for _, p := range report.Problems {
+		kinds[p.Kind] = p.Path
+	}
+
+	return kinds
+}
+
+func TestValidate_ShouldAcceptCommittedTries(t *testing.T) {
+	db := NewInMemoryStorage()
+	_, roots := commitVersions(t, db, 3)
+
+	report, err := Validate(roots[2], db)
+	require.NoError(t, err)
+	require.True(t, report.Valid(), report.Problems)
+	require.Greater(t, report.Nodes, 300)
+
+	trie := NewTrie(WithHashedValues(33, nil))
+	require.NoError(t, trie.Put([]byte("big"), bytes.Repeat([]byte{1}, 40)))
+	require.NoError(t, trie.Put([]byte("bit"), []byte("small")))
+	root, err := trie.Commit(db)
+	require.NoError(t, err)
+
+	report, err = Validate(root, db, WithHashedValues(33, nil))
+	require.NoError(t, err)
+	require.True(t, report.Valid(), report.Problems)
+
+	report, err = Validate(NewTrie().Hash(), db)
+	require.NoError(t, err)
+	require.Zero(t, report.Nodes)
+}
+
+func TestValidate_ShouldReportCorruptedNodes(t *testing.T) {
+	db := NewInMemoryStorage()
+	trie := buildTrie(t, namespacedKeys)
+	root, err := trie.Commit(db)
+	require.NoError(t, err)
+
+	// every node below the root references its children by hash
+	encoded, err := db.Get(root)
+	require.NoError(t, err)
+
+	n, err := DecodeNode(encoded)
+	require.NoError(t, err)
+
+	var children [][]byte
+	var paths [][]Nibble
+	hashedChildren(n, func(rel []Nibble, hash []byte) {
+		children = append(children, hash)
+		paths = append(paths, rel)
+	})
+	require.GreaterOrEqual(t, len(children), 2)
+
+	// a node that decodes fine but is not the one referenced
+	other := NewLeafNodeFromNibbles([]Nibble{1}, bytes.Repeat([]byte("x"), 40))
+	require.NoError(t, db.Put(children[0], Serialize(other)))
+	require.NoError(t, db.Delete(children[1]))
+
+	report, err := Validate(root, db)
+	require.NoError(t, err)
+	require.Equal(t, []Problem{
+		{Kind: MissingNode, Path: paths[1], Hash: children[1]},
+		{Kind: HashMismatch, Path: paths[0], Hash: children[0]},
+	}, report.Problems)
+
+	// a blob stored under its own hash that is not a node
+	garbage := []byte{0xc3, 0x01}
+	require.NoError(t, db.Put(Keccak256.Hash(garbage), garbage))
+
+	parent := NewBranchNode()
+	parent.SetBranch(3, HashNode(Keccak256.Hash(garbage)))
+	parent.SetBranch(4, NewLeafNodeFromNibbles([]Nibble{5}, []byte("a")))
+
+	report, err = Validate(storeRoot(t, parent, db), db)
+	require.NoError(t, err)
+	require.Equal(t, map[ProblemKind][]Nibble{UndecodableNode: {3}}, problemKinds(report))
+}
+
+func TestValidate_ShouldNotFollowCycles(t *testing.T) {
+	db := NewInMemoryStorage()
+	trie := buildTrie(t, namespacedKeys)
+	root, err := trie.Commit(db)
+	require.NoError(t, err)
+
+	encoded, err := db.Get(root)
+	require.NoError(t, err)
+
+	n, err := DecodeNode(encoded)
+	require.NoError(t, err)
+
+	var children [][]byte
+	hashedChildren(n, func(rel []Nibble, hash []byte) {
+		children = append(children, hash)
+	})
+	require.NotEmpty(t, children)
+
+	// the root stored under the hash of its child makes the child its own
+	// parent, the corrupted node is reported and not walked into
+	require.NoError(t, db.Put(children[0], encoded))
+
+	report, err := Validate(root, db)
+	require.NoError(t, err)
+	require.Len(t, report.Problems, 1)
+	require.Equal(t, HashMismatch, report.Problems[0].Kind)
+	require.Equal(t, children[0], report.Problems[0].Hash)
+}
+
+func TestValidate_ShouldReportNonCanonicalShapes(t *testing.T) {
+	db := NewInMemoryStorage()
+
+	// a branch with one child holding a leaf that ends half a byte
+	short := NewBranchNode()
+	short.SetBranch(2, NewLeafNodeFromNibbles([]Nibble{3, 4, 5}, []byte("odd")))
+
+	// an extension with an empty path followed by another extension
+	inner := NewBranchNode()
+	inner.SetBranch(0, NewLeafNodeFromNibbles([]Nibble{1}, []byte("a")))
+	inner.SetBranch(1, NewLeafNodeFromNibbles([]Nibble{2}, []byte("b")))
+	chained := NewExtensionNode(nil, NewExtensionNode([]Nibble{7}, inner))
+
+	root := NewBranchNode()
+	root.SetBranch(1, short)
+	root.SetBranch(2, chained)
+
+	report, err := Validate(storeRoot(t, root, db), db)
+	require.NoError(t, err)
+
+	require.Equal(t, map[ProblemKind][]Nibble{
+		ShortBranch:           {1},
+		LeafNotAtKeyEnd:       {1, 2},
+		EmptyExtension:        {2},
+		ConsecutiveExtensions: {2},
+	}, problemKinds(report))
+
+	require.Equal(t, "branch with fewer than two entries at path \"1\"", report.Problems[0].String())
+
+	// a branch holding only a value, Put would have made it a leaf
+	lone := NewBranchNode()
+	lone.SetValue([]byte("alone"))
+
+	root = NewBranchNode()
+	root.SetBranch(3, NewExtensionNode([]Nibble{4}, lone))
+	root.SetBranch(5, NewLeafNodeFromNibbles([]Nibble{6}, []byte("b")))
+
+	report, err = Validate(storeRoot(t, root, db), db)
+	require.NoError(t, err)
+	require.Equal(t, map[ProblemKind][]Nibble{ShortBranch: {3, 4}}, problemKinds(report))
+}
@@ -0,0 +1,118 @@
+package mptrie
+
+import (
+	"bytes"
+	"errors"
+)
+
+var (
+	ErrValueHashMismatch = errors.New("stored value does not match its hash")
+)
+
+// ValueStore keeps the values referenced by hash, keyed by their hash
+type ValueStore interface {
+	KVWriter
+	NodeReader
+}
+
+// WithInlineThreshold embeds in their parents the nodes whose encoding is
+// shorter than n bytes, bigger nodes are referenced by hash. The default is
+// 32, the size of a hash. SubstrateCodec cannot tell embedded nodes of 32
+// bytes from hashes, so it needs n to be at most 32.
+func WithInlineThreshold(n int) Option {
+	return func(t *Trie) {
+		if n < 1 {
+			n = 1
+		}
+
+		t.inline = n
+	}
+}
+
+// WithHashedValues stores the values of threshold bytes or more apart from
+// the nodes, keyed by their hash, and keeps only that hash in the node
+// encodings. Commit writes those values into values, or with the nodes when
+// values is nil, and OpenTrie loads them from there. Proofs then carry only
+// the values of the proved keys.
+func WithHashedValues(threshold int, values ValueStore) Option {
+	return func(t *Trie) {
+		t.hashedValues = threshold
+		t.values = values
+	}
+}
+
+// loadValues fetches the values referenced by hash in a node just decoded,
+// and in its embedded children, so the nodes in a trie always hold their
+// values.
+func (t Trie) loadValues(n Node) error {
+	var err error
+
+	switch node := n.(type) {
+	case *LeafNode:
+		node.Value, err = t.loadValue(node.Value, node.ValueHash)
+		node.ValueHash = nil
+	case *BranchNode:
+		node.Value, err = t.loadValue(node.Value, node.ValueHash)
+		node.ValueHash = nil
+
+		for _, child := range node.Branches {
+			if err == nil {
+				err = t.loadValues(child)
+			}
+		}
+	case *ExtensionNode:
+		err = t.loadValues(node.Next)
+	}
+
+	return err
+}
+
+// loadValue returns value, or the value stored under hash when the node was
+// decoded without it
+func (t Trie) loadValue(value, hash []byte) ([]byte, error) {
+	if value != nil || hash == nil {
+		return value, nil
+	}
+
+	var store NodeReader = t.db
+	if t.values != nil {
+		store = t.values
+	}
+
+	if store == nil {
+		return nil, ErrMissingStorage
+	}
+
+	return readValue(store, t.encoder(), hash)
+}
+
+// readValue loads the value stored under hash checking it really hashes to it
+func readValue(r NodeReader, e encoder, hash []byte) ([]byte, error) {
+	value, err := r.Get(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(e.hasher.Hash(value), hash) {
+		return nil, ErrValueHashMismatch
+	}
+
+	return value, nil
+}
+
+// storedValue returns the value kept in a leaf or branch and the hash it is
+// referenced by when it is stored apart
+func storedValue(n Node, e encoder) (value, hash []byte) {
+	switch node := n.(type) {
+	case *LeafNode:
+		value, hash = node.Value, node.ValueHash
+	case *BranchNode:
+		value, hash = node.Value, node.ValueHash
+	}
+
+	if ref, hashed := valueReference(value, hash, e.valueReference); hashed {
+		hash = ref
+	}
+
+	return value, hash
+}
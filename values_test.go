@@ -0,0 +1,197 @@
+package mptrie
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// storageSize is the number of bytes kept by s
+func storageSize(s *InMemoryStorage) int {
+	size := 0
+	for _, v := range s.kv {
+		size += len(v)
+	}
+
+	return size
+}
+
+func TestInlineThreshold_ShouldChangeWhichNodesAreEmbedded(t *testing.T) {
+	def := buildTrie(t, routeKeys)
+
+	defDB := NewInMemoryStorage()
+	_, err := def.Commit(defDB)
+	require.NoError(t, err)
+
+	for _, threshold := range []int{1, 64} {
+		trie := NewTrie(WithInlineThreshold(threshold))
+		for _, k := range routeKeys {
+			require.NoError(t, trie.Put([]byte(k), []byte("value of "+k)))
+		}
+
+		require.NotEqual(t, def.Hash(), trie.Hash())
+
+		db := NewInMemoryStorage()
+		root, err := trie.Commit(db)
+		require.NoError(t, err)
+
+		if threshold == 1 {
+			require.Greater(t, len(db.kv), len(defDB.kv))
+		} else {
+			require.Less(t, len(db.kv), len(defDB.kv))
+		}
+
+		opened := OpenTrie(root, db, WithInlineThreshold(threshold))
+		require.Equal(t, root, opened.Hash())
+
+		for _, k := range routeKeys {
+			v, ok, err := opened.TryGet([]byte(k))
+			require.NoError(t, err)
+			require.True(t, ok)
+			require.Equal(t, []byte("value of "+k), v)
+		}
+	}
+}
+
+func TestHashedValues_ShouldStoreBigValuesApart(t *testing.T) {
+	big := bytes.Repeat([]byte{0xab}, 1024)
+	values := NewInMemoryStorage()
+
+	trie := NewTrie(WithHashedValues(33, values))
+	plain := NewTrie()
+	for _, k := range routeKeys {
+		value := []byte("value of " + k)
+		if k == "service" || k == "storage/eu" {
+			value = big
+		}
+
+		require.NoError(t, trie.Put([]byte(k), value))
+		require.NoError(t, plain.Put([]byte(k), value))
+	}
+
+	require.NotEqual(t, plain.Hash(), trie.Hash())
+
+	db := NewInMemoryStorage()
+	root, err := trie.Commit(db)
+	require.NoError(t, err)
+	require.Equal(t, trie.Hash(), root)
+
+	// the same big value is stored once, under its hash
+	require.Len(t, values.kv, 1)
+	stored, err := values.Get(Keccak256.Hash(big))
+	require.NoError(t, err)
+	require.Equal(t, big, stored)
+	require.Less(t, storageSize(db), len(big))
+
+	opened := OpenTrie(root, db, WithHashedValues(33, values))
+	require.Equal(t, root, opened.Hash())
+
+	for _, k := range routeKeys {
+		expected, _ := plain.Get([]byte(k))
+		v, ok, err := opened.TryGet([]byte(k))
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, expected, v)
+	}
+
+	require.NoError(t, opened.Delete([]byte("service/eu")))
+	require.NoError(t, trie.Delete([]byte("service/eu")))
+	require.Equal(t, trie.Hash(), opened.Hash())
+
+	_, _, err = OpenTrie(root, db, WithHashedValues(33, NewInMemoryStorage())).TryGet([]byte("storage/eu"))
+	require.ErrorIs(t, err, KeyNotFound)
+}
+
+func TestHashedValues_ShouldShareTheNodeStoreWithoutValueStore(t *testing.T) {
+	big := bytes.Repeat([]byte{0xcd}, 100)
+
+	trie := NewTrie(WithHashedValues(33, nil))
+	require.NoError(t, trie.Put([]byte("a"), big))
+	require.NoError(t, trie.Put([]byte("ab"), []byte("small")))
+
+	db := NewInMemoryStorage()
+	root, err := trie.Commit(db)
+	require.NoError(t, err)
+
+	opened := OpenTrie(root, db, WithHashedValues(33, nil))
+	v, ok, err := opened.TryGet([]byte("a"))
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, big, v)
+}
+
+func TestHashedValues_ProofsShouldOnlyCarryTheProvedValue(t *testing.T) {
+	big := bytes.Repeat([]byte{0xef}, 4096)
+
+	build := func(opts ...Option) *Trie {
+		trie := NewTrie(opts...)
+		for _, k := range routeKeys {
+			require.NoError(t, trie.Put([]byte(k), big))
+		}
+
+		return trie
+	}
+
+	plain := build()
+	hashed := build(WithHashedValues(33, nil))
+
+	for _, q := range []string{"service/eu/host-01", "service/us/host-02"} {
+		plainProof := NewInMemoryStorage()
+		require.NoError(t, CreateLongestPrefixProof([]byte(q), plain, plainProof))
+
+		hashedProof := NewInMemoryStorage()
+		require.NoError(t, CreateLongestPrefixProof([]byte(q), hashed, hashedProof))
+
+		// the plain proof holds the value of every prefix on the path, the
+		// hashed one only the value of the matched key
+		require.Less(t, storageSize(hashedProof), 2*len(big))
+		require.Greater(t, storageSize(plainProof), 2*len(big))
+
+		key, value, ok, err := VerifyLongestPrefixProof(hashed.Hash(), []byte(q), hashedProof, WithHashedValues(33, nil))
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, []byte(q), key)
+		require.Equal(t, big, value)
+	}
+
+	proof := NewInMemoryStorage()
+	require.NoError(t, CreateLongestPrefixProof([]byte("service"), hashed, proof))
+	require.NoError(t, proof.Put(Keccak256.Hash(big), []byte("forged")))
+
+	_, _, _, err := VerifyLongestPrefixProof(hashed.Hash(), []byte("service"), proof, WithHashedValues(33, nil))
+	require.ErrorIs(t, err, ErrValueHashMismatch)
+}
+
+func TestHashedValues_ShouldWorkWithSubstrateCodec(t *testing.T) {
+	big := bytes.Repeat([]byte{0x01}, 64)
+	opts := []Option{WithCodec(SubstrateCodec), WithHasher(Blake2b), WithHashedValues(33, nil)}
+
+	trie := NewTrie(opts...)
+	for _, k := range routeKeys {
+		require.NoError(t, trie.Put([]byte(k), append([]byte(k), big...)))
+	}
+
+	db := NewInMemoryStorage()
+	root, err := trie.Commit(db)
+	require.NoError(t, err)
+
+	opened := OpenTrie(root, db, opts...)
+	require.Equal(t, root, opened.Hash())
+
+	for _, k := range routeKeys {
+		v, ok, err := opened.TryGet([]byte(k))
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, append([]byte(k), big...), v)
+	}
+
+	// a leaf with the value hash uses the V1 header
+	leaf := &LeafNode{Path: []Nibble{1, 2, 3}, ValueHash: Blake2b.Hash(big)}
+	encoded := SubstrateCodec.Encode(leaf, nil, nil)
+	require.Equal(t, byte(0x23), encoded[0])
+
+	decoded, err := SubstrateCodec.Decode(encoded)
+	require.NoError(t, err)
+	require.Equal(t, leaf, decoded)
+}